@@ -0,0 +1,180 @@
+// Package cache provides a cache for results of build steps.
+//
+//spellchecker:words generator
+package cache
+
+//spellchecker:words context sha256 binary slog sync
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+)
+
+// Key identifies a cached result.
+// It is a hash of all inputs of a build step.
+type Key [sha256.Size]byte
+
+// NewKey creates a new key from the given kind of step and its inputs.
+// Different kinds never produce the same key, even if they have identical inputs.
+func NewKey(kind string, inputs ...[]byte) Key {
+	h := sha256.New()
+
+	write := func(input []byte) {
+		// prefix each input with its length to prevent ambiguity
+		_, _ = h.Write(binary.BigEndian.AppendUint64(nil, uint64(len(input))))
+		_, _ = h.Write(input)
+	}
+
+	write([]byte(kind))
+	for _, input := range inputs {
+		write(input)
+	}
+
+	var key Key
+	h.Sum(key[:0])
+	return key
+}
+
+// String returns a hex representation of this key.
+func (key Key) String() string {
+	return hex.EncodeToString(key[:])
+}
+
+// Cache holds results of build steps in memory.
+//
+// Results not used during a run are discarded once the run finishes, see [Cache.Sweep].
+// A nil Cache is valid, and never holds any results.
+type Cache struct {
+	// Store is an optional on-disk store used by [Persist].
+	Store *Store
+
+	m       sync.Mutex
+	entries map[Key]any
+	used    map[Key]struct{}
+}
+
+// New creates a new empty cache.
+func New() *Cache {
+	return &Cache{
+		entries: make(map[Key]any),
+		used:    make(map[Key]struct{}),
+	}
+}
+
+// Get returns the result stored under key, if any.
+func (cache *Cache) Get(key Key) (value any, ok bool) {
+	if cache == nil {
+		return nil, false
+	}
+
+	cache.m.Lock()
+	defer cache.m.Unlock()
+
+	value, ok = cache.entries[key]
+	if ok {
+		cache.used[key] = struct{}{}
+	}
+	return value, ok
+}
+
+// Put stores a result under key.
+// Stored values must not be modified afterwards.
+func (cache *Cache) Put(key Key, value any) {
+	if cache == nil {
+		return
+	}
+
+	cache.m.Lock()
+	defer cache.m.Unlock()
+
+	cache.entries[key] = value
+	cache.used[key] = struct{}{}
+}
+
+// Sweep removes all results that have not been used since the previous call to Sweep.
+// It returns the number of results kept and removed.
+func (cache *Cache) Sweep() (kept, removed int) {
+	if cache == nil {
+		return 0, 0
+	}
+
+	cache.m.Lock()
+	defer cache.m.Unlock()
+
+	for key := range cache.entries {
+		if _, ok := cache.used[key]; !ok {
+			delete(cache.entries, key)
+			removed++
+		}
+	}
+	clear(cache.used)
+
+	return len(cache.entries), removed
+}
+
+// Do returns the result stored under key in cache.
+// If there is no such result, it invokes compute and stores the result unless an error is returned.
+//
+// Concurrent calls with the same key may invoke compute multiple times.
+func Do[T any](cache *Cache, key Key, compute func() (T, error)) (T, error) {
+	if value, ok := cache.Get(key); ok {
+		if result, ok := value.(T); ok {
+			return result, nil
+		}
+	}
+
+	result, err := compute()
+	if err != nil {
+		return result, err
+	}
+
+	cache.Put(key, result)
+	return result, nil
+}
+
+// Persist is like [Do], but additionally keeps results in the [Store] of cache.
+// This allows results to be reused by later invocations of the generator.
+//
+// Because results are shared between different executables, key must include everything the result depends on.
+// In particular this includes the version of the code computing the result, see [ModuleVersion] and [ExecutableVersion].
+//
+// Failing to write to the store is not fatal, and only logged.
+func Persist(cache *Cache, logger *slog.Logger, key Key, compute func() ([]byte, error)) ([]byte, error) {
+	return Do(cache, key, func() ([]byte, error) {
+		var store *Store
+		if cache != nil {
+			store = cache.Store
+		}
+
+		if data, ok := store.Get(key); ok {
+			return data, nil
+		}
+
+		data, err := compute()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := store.Put(key, data); err != nil {
+			logger.Error("failed to write cache entry", slog.String("key", key.String()), slog.Any("error", err))
+		}
+		return data, nil
+	})
+}
+
+type contextKey struct{}
+
+// WithCache returns a new context holding the given cache.
+func WithCache(ctx context.Context, cache *Cache) context.Context {
+	return context.WithValue(ctx, contextKey{}, cache)
+}
+
+// FromContext returns the cache stored in the given context.
+// If the context does not hold a cache, returns nil, which can still be used.
+func FromContext(ctx context.Context) *Cache {
+	cache, _ := ctx.Value(contextKey{}).(*Cache)
+	return cache
+}
@@ -0,0 +1,251 @@
+//spellchecker:words generator
+package cache
+
+//spellchecker:words bytes sha256 errors path filepath slices strings time
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// storeFormat is the version of the on-disk format of a [Store].
+// Changing it invalidates all existing entries.
+const storeFormat = "v1"
+
+// Store is a content-addressed on-disk cache that is shared between runs of the generator.
+//
+// Entries are stored as individual files inside a directory.
+// Each entry holds a checksum of its contents, corrupted entries are ignored.
+// Reading an entry marks it as recently used.
+// Once the store grows beyond its maximum size, least recently used entries are evicted.
+//
+// A nil Store is valid, and never holds any entries.
+type Store struct {
+	dir     string
+	maxSize int64
+}
+
+// NewStore creates a new store in the given directory.
+// The directory is created when the first entry is written.
+//
+// If maxSize is positive, [Store.Evict] removes entries until the total size is at most maxSize bytes.
+func NewStore(dir string, maxSize int64) *Store {
+	return &Store{dir: dir, maxSize: maxSize}
+}
+
+// Dir returns the directory of this store.
+func (store *Store) Dir() string {
+	return store.dir
+}
+
+// root returns the directory holding all entries of the current format.
+func (store *Store) root() string {
+	return filepath.Join(store.dir, storeFormat)
+}
+
+// tempPrefix is the prefix of temporary files written by [Store.Put].
+const tempPrefix = ".tmp-"
+
+// path returns the path of the file holding the given key.
+func (store *Store) path(key Key) string {
+	name := key.String()
+	return filepath.Join(store.root(), name[:2], name[2:])
+}
+
+var errChecksum = errors.New("checksum mismatch")
+
+// Get returns the entry stored under key.
+func (store *Store) Get(key Key) ([]byte, bool) {
+	if store == nil {
+		return nil, false
+	}
+
+	path := store.path(key)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	data, err = verifyEntry(data)
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, false
+	}
+
+	// mark the entry as recently used
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	return data, true
+}
+
+// Put stores data under key.
+// The entry is written atomically, concurrent readers never observe a partially written entry.
+func (store *Store) Put(key Key, data []byte) (e error) {
+	if store == nil {
+		return nil
+	}
+
+	path := store.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		if e != nil {
+			_ = os.Remove(temp.Name())
+		}
+	}()
+
+	sum := sha256.Sum256(data)
+	_, errWrite := temp.Write(append(sum[:], data...))
+	errClose := temp.Close()
+	if err := errors.Join(errWrite, errClose); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename temporary file: %w", err)
+	}
+	return nil
+}
+
+// verifyEntry verifies the checksum of an entry read from disk, and returns its contents.
+func verifyEntry(entry []byte) ([]byte, error) {
+	if len(entry) < sha256.Size {
+		return nil, errChecksum
+	}
+
+	sum, data := entry[:sha256.Size], entry[sha256.Size:]
+	if actual := sha256.Sum256(data); !bytes.Equal(sum, actual[:]) {
+		return nil, errChecksum
+	}
+	return data, nil
+}
+
+// StoreStats holds statistics about a [Store].
+type StoreStats struct {
+	Entries int   // number of entries
+	Size    int64 // total size of all entries in bytes
+
+	Oldest, Newest time.Time // last use of the least and most recently used entries
+}
+
+// storeEntry is a single entry found on disk.
+type storeEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// entries lists all entries of this store.
+// Entries that are still being written are skipped.
+func (store *Store) entries() ([]storeEntry, error) {
+	var entries []storeEntry
+	err := filepath.WalkDir(store.root(), func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), tempPrefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, storeEntry{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list entries: %w", err)
+	}
+	return entries, nil
+}
+
+// Stats returns statistics about this store.
+func (store *Store) Stats() (stats StoreStats, err error) {
+	if store == nil {
+		return stats, nil
+	}
+
+	entries, err := store.entries()
+	if err != nil {
+		return stats, err
+	}
+
+	for _, entry := range entries {
+		stats.Entries++
+		stats.Size += entry.size
+		if stats.Oldest.IsZero() || entry.modTime.Before(stats.Oldest) {
+			stats.Oldest = entry.modTime
+		}
+		if entry.modTime.After(stats.Newest) {
+			stats.Newest = entry.modTime
+		}
+	}
+	return stats, nil
+}
+
+// Evict removes least recently used entries until the store is no larger than its maximum size.
+// It returns the number of entries removed.
+func (store *Store) Evict() (removed int, err error) {
+	if store == nil || store.maxSize <= 0 {
+		return 0, nil
+	}
+
+	entries, err := store.entries()
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, entry := range entries {
+		size += entry.size
+	}
+	if size <= store.maxSize {
+		return 0, nil
+	}
+
+	slices.SortFunc(entries, func(left, right storeEntry) int {
+		return cmp.Or(left.modTime.Compare(right.modTime), cmp.Compare(left.path, right.path))
+	})
+	for _, entry := range entries {
+		if size <= store.maxSize {
+			break
+		}
+		if err := os.Remove(entry.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, fmt.Errorf("failed to remove entry: %w", err)
+		}
+		size -= entry.size
+		removed++
+	}
+	return removed, nil
+}
+
+// Clear removes all entries from this store, including those of older formats.
+func (store *Store) Clear() error {
+	if store == nil {
+		return nil
+	}
+	if err := os.RemoveAll(store.dir); err != nil {
+		return fmt.Errorf("failed to remove cache directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+//spellchecker:words generator
+package cache
+
+//spellchecker:words sha256 runtime debug sync
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"runtime/debug"
+	"sync"
+)
+
+// ExecutableVersion returns a version identifying the code of the running executable.
+// It is intended to be part of keys for results that depend on arbitrary configuration in code.
+//
+// The version is a hash of the executable, so it stays the same when an unchanged program is rebuilt.
+// If the executable cannot be read, a random version is used, and results are never reused between processes.
+var ExecutableVersion = sync.OnceValue(func() string {
+	if version, ok := hashExecutable(); ok {
+		return version
+	}
+	return "random-" + rand.Text()
+})
+
+func hashExecutable() (string, bool) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", false
+	}
+
+	executable, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer executable.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, executable); err != nil {
+		return "", false
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// ModuleVersion returns the version of the given module that the running executable was built with.
+// It is intended to be part of keys for results that only depend on code of that module.
+//
+// If the version of the module is unknown, returns [ExecutableVersion] instead.
+func ModuleVersion(path string) string {
+	info, ok := debug.ReadBuildInfo()
+	if ok {
+		for _, dep := range info.Deps {
+			if dep.Path != path {
+				continue
+			}
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			if dep.Version != "" && dep.Version != "(devel)" {
+				return dep.Path + "@" + dep.Version
+			}
+		}
+	}
+	return ExecutableVersion()
+}
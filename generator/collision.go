@@ -0,0 +1,53 @@
+//spellchecker:words generator
+package generator
+
+//spellchecker:words errors slog
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"go.tkw01536.de/blog/generator/file"
+)
+
+var errPathCollision = errors.New("output path produced more than once")
+
+// resolveCollisions checks that no two files share the same path.
+//
+// If a path is listed in [Generator.Overrides], the file produced by the named scanner replaces the other one.
+// Any other collision results in an error naming both producers.
+// The order of the remaining files is preserved.
+func (generator *Generator) resolveCollisions(logger *slog.Logger, files []file.File) ([]file.File, error) {
+	var (
+		indexes = make(map[string]int, len(files)) // index of each path in result
+		result  = make([]file.File, 0, len(files))
+		errs    []error
+	)
+	for _, f := range files {
+		index, ok := indexes[f.Path]
+		if !ok {
+			indexes[f.Path] = len(result)
+			result = append(result, f)
+			continue
+		}
+
+		existing := result[index]
+		winner, ok := generator.Overrides[f.Path]
+		switch {
+		case ok && winner == f.Origin.Scanner && winner != existing.Origin.Scanner:
+			result[index] = f
+		case ok && winner == existing.Origin.Scanner && winner != f.Origin.Scanner:
+			existing, f = f, existing
+		default:
+			errs = append(errs, fmt.Errorf("%w: %q by %s and %s", errPathCollision, f.Path, existing.Origin, f.Origin))
+			continue
+		}
+
+		logger.Info("overriding output path", slog.String("path", f.Path), slog.String("winner", f.Origin.String()), slog.String("discarded", existing.Origin.String()))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
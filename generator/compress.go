@@ -0,0 +1,187 @@
+//spellchecker:words generator
+package generator
+
+//spellchecker:words bytes compress gzip context slog path runtime strings sync github klauspost zstd
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+
+	"go.tkw01536.de/blog/generator/cache"
+	"go.tkw01536.de/blog/generator/file"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Precompress adds precompressed variants of compressible files to the output.
+//
+// For every compressible file "name" it adds "name.gz", and optionally "name.zst".
+// Variants are only added when they are smaller than the original.
+// Compression is deterministic, so identical inputs always produce identical variants.
+//
+// Compressed variants are persisted, see [cache.Persist].
+//
+// Use [Precompress.Finalize] as a [Finalizer].
+// It should come after any other finalizer that modifies files.
+type Precompress struct {
+	// MinSize is the minimum size in bytes of files to compress.
+	MinSize int
+
+	// Zstd also adds zstd-compressed variants.
+	Zstd bool
+
+	// Match determines if the file with the given path is compressible.
+	// If nil, files with common text extensions are compressed.
+	Match func(path string) bool
+}
+
+// zstd compression settings.
+const (
+	zstdModule = "github.com/klauspost/compress"
+	zstdLevel  = zstd.SpeedBetterCompression
+)
+
+// compressibleExtensions are extensions compressed when [Precompress.Match] is nil.
+var compressibleExtensions = map[string]bool{
+	".html": true,
+	".htm":  true,
+	".css":  true,
+	".js":   true,
+	".mjs":  true,
+	".json": true,
+	".xml":  true,
+	".svg":  true,
+	".txt":  true,
+	".map":  true,
+}
+
+// Finalize adds precompressed variants of all compressible files.
+// It implements [Finalizer].
+func (pc *Precompress) Finalize(ctx context.Context, logger *slog.Logger, files []file.File) ([]file.File, error) {
+	var encoder *zstd.Encoder
+	if pc.Zstd {
+		var err error
+		encoder, err = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstdLevel))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		defer encoder.Close()
+	}
+
+	var (
+		wg sync.WaitGroup
+
+		variants = make([][]file.File, len(files)) // variants of each file
+		errs     = make([]error, len(files))       // error compressing each file
+	)
+	for i, f := range files {
+		if len(f.Contents) < pc.MinSize || !pc.compressible(f.Path) {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := ctx.Err(); err != nil {
+				return
+			}
+
+			found, err := compressVariants(ctx, logger, f, encoder)
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to compress %q: %w", f.Path, err)
+				return
+			}
+
+			logger.Info("precompressed file", slog.String("path", f.Path), slog.Int("variants", len(found)))
+			variants[i] = found
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, found := range variants {
+		files = append(files, found...)
+	}
+	return files, nil
+}
+
+// compressVariants returns the compressed variants of f that are smaller than f itself.
+// If encoder is not nil, it is used to add a zstd-compressed variant.
+func compressVariants(ctx context.Context, logger *slog.Logger, f file.File, encoder *zstd.Encoder) ([]file.File, error) {
+	c := cache.FromContext(ctx)
+
+	gzKey := cache.NewKey("gzip", []byte(runtime.Version()), f.Contents)
+	compressed, err := cache.Persist(c, logger, gzKey, func() ([]byte, error) {
+		return compressGzip(f.Contents)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var variants []file.File
+	if len(compressed) < len(f.Contents) {
+		variants = append(variants, file.File{Path: f.Path + ".gz", Contents: compressed, Origin: f.Origin})
+	}
+
+	if encoder != nil {
+		zstKey := cache.NewKey("zstd", []byte(cache.ModuleVersion(zstdModule)), []byte(zstdLevel.String()), f.Contents)
+		compressed, err := cache.Persist(c, logger, zstKey, func() ([]byte, error) {
+			// EncodeAll is safe for concurrent use
+			return encoder.EncodeAll(f.Contents, nil), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(compressed) < len(f.Contents) {
+			variants = append(variants, file.File{Path: f.Path + ".zst", Contents: compressed, Origin: f.Origin})
+		}
+	}
+
+	return variants, nil
+}
+
+// compressible checks if the file with the given path should be compressed.
+func (pc *Precompress) compressible(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == ".gz" || ext == ".zst" {
+		return false
+	}
+	if pc.Match != nil {
+		return pc.Match(name)
+	}
+	return compressibleExtensions[ext]
+}
+
+// compressGzip compresses data using gzip.
+// The gzip header does not contain a name or modification time.
+func compressGzip(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	writer, err := gzip.NewWriterLevel(&buffer, gzip.BestCompression)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create writer: %w", err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to write: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close writer: %w", err)
+	}
+
+	return buffer.Bytes(), nil
+}
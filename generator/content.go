@@ -1,7 +1,7 @@
 //spellchecker:words generator
 package generator
 
-//spellchecker:words bytes context html template slog
+//spellchecker:words bytes context html template slog time
 import (
 	"bytes"
 	"context"
@@ -9,18 +9,38 @@ import (
 	"html/template"
 	"io"
 	"log/slog"
+	"time"
 
+	"go.tkw01536.de/blog/generator/cache"
 	"go.tkw01536.de/blog/generator/file"
 )
 
 // IndexTemplate is a template to be used for generation.
 type ContentTemplate struct {
-	Template *template.Template // Template used for actual rendering, is passed [ContentTemplateContext].
-	Globals  any                // Global Data to be passed.
+	Template TemplateSource // Template used for actual rendering, is passed [ContentTemplateContext].
+	Globals  any            // Global Data to be passed.
+
+	loaded  *template.Template // template loaded for the current run
+	version string             // version of loaded, see [TemplateSource]
+}
+
+// load loads the template for the current run.
+func (ctc *ContentTemplate) load() error {
+	tpl, version, err := ctc.Template.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load content template: %w", err)
+	}
+	ctc.loaded, ctc.version = tpl, version
+	return nil
 }
 
 func (ctc *ContentTemplate) Execute(w io.Writer, file file.FileWithMetadata) error {
-	return ctc.Template.Execute(w, &ContentTemplateContext{
+	if ctc.loaded == nil {
+		if err := ctc.load(); err != nil {
+			return err
+		}
+	}
+	return ctc.loaded.Execute(w, &ContentTemplateContext{
 		File:     file,
 		Template: ctc,
 	})
@@ -32,17 +52,46 @@ type ContentTemplateContext struct {
 	Template *ContentTemplate
 }
 
+// key returns a cache key for rendering the given file with this template.
+func (ctc *ContentTemplate) key(f file.FileWithMetadata) cache.Key {
+	return cache.NewKey(
+		"content",
+		[]byte(ctc.version),
+		fmt.Appendf(nil, "%#v", ctc.Globals),
+		[]byte(f.Path),
+		f.Contents,
+		fmt.Appendf(nil, "%#v", f.Metadata),
+	)
+}
+
 // renderFile renders a single [FileWithMetadata] through the [ContentTemplate]
 func (generator *Generator) renderFile(ctx context.Context, logger *slog.Logger, f file.FileWithMetadata) (file.File, error) {
-	logger.Info("generating content file", slog.String("path", f.Path))
+	key := generator.ContentTemplate.key(f)
+
+	var took time.Duration // stays zero when the cached result is used
+	result, err := cache.Do(cache.FromContext(ctx), key, func() (file.File, error) {
+		logger.Info("generating content file", slog.String("path", f.Path))
+
+		start := time.Now()
+		defer func() { took = time.Since(start) }()
 
-	var out bytes.Buffer
-	if err := generator.ContentTemplate.Execute(&out, f); err != nil {
-		return file.File{}, fmt.Errorf("failed to render content %q: %w", f.Path, err)
+		var out bytes.Buffer
+		if err := generator.ContentTemplate.Execute(&out, f); err != nil {
+			return file.File{}, file.NewError(f.File, fmt.Errorf("failed to render content %q: %w", f.Path, err))
+		}
+
+		return file.File{
+			Path:     f.Path,
+			Contents: out.Bytes(),
+		}, nil
+	})
+	if err != nil {
+		return file.File{}, err
 	}
 
-	return file.File{
-		Path:     f.Path,
-		Contents: out.Bytes(),
-	}, nil
+	summaryFromContext(ctx).render(f.Path, took)
+
+	// the cached result may have been rendered from a different origin
+	result.Origin = f.Origin
+	return result, nil
 }
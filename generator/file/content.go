@@ -0,0 +1,99 @@
+package file
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// feedSniffLength is the number of bytes inspected when detecting feeds.
+const feedSniffLength = 1024
+
+// ContentType determines the content type to serve the file with the given path and contents with.
+//
+// The content type is primarily determined by the extension.
+// Feeds are detected by their contents, as they commonly use generic extensions such as ".xml" or ".json".
+// If the extension is unknown, the content type is sniffed from the contents.
+func ContentType(name string, contents []byte) string {
+	head := contents[:min(len(contents), feedSniffLength)]
+
+	switch ext := strings.ToLower(path.Ext(name)); {
+	case ext == ".rss" || (ext == ".xml" && bytes.Contains(head, []byte("<rss"))):
+		return "application/rss+xml; charset=utf-8"
+	case ext == ".atom" || (ext == ".xml" && bytes.Contains(head, []byte("<feed"))):
+		return "application/atom+xml; charset=utf-8"
+	case ext == ".json" && bytes.Contains(head, []byte("jsonfeed.org/version")):
+		return "application/feed+json; charset=utf-8"
+	}
+
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType
+	}
+	if len(contents) == 0 {
+		return "application/octet-stream"
+	}
+	return http.DetectContentType(contents)
+}
+
+// RedirectTarget checks if the given html page immediately redirects to another url using a meta refresh, as produced by the redirect scanner.
+// If so, it returns the target url and true.
+func RedirectTarget(page []byte) (string, bool) {
+	tokenizer := html.NewTokenizer(bytes.NewReader(page))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return "", false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "meta":
+				if target, ok := refreshTarget(&token); ok {
+					return target, true
+				}
+			case "body":
+				// refreshes only count inside the head
+				return "", false
+			}
+		}
+	}
+}
+
+// refreshTarget returns the target of a meta refresh token without delay.
+func refreshTarget(token *html.Token) (string, bool) {
+	var refresh bool
+	var content string
+	for _, attr := range token.Attr {
+		switch attr.Key {
+		case "http-equiv":
+			refresh = strings.EqualFold(strings.TrimSpace(attr.Val), "refresh")
+		case "content":
+			content = attr.Val
+		}
+	}
+	if !refresh {
+		return "", false
+	}
+
+	delay, target, ok := strings.Cut(content, ";")
+	if !ok {
+		return "", false
+	}
+	if seconds, err := strconv.ParseFloat(strings.TrimSpace(delay), 64); err != nil || seconds != 0 {
+		return "", false
+	}
+
+	target = strings.TrimSpace(target)
+	if len(target) < len("url=") || !strings.EqualFold(target[:len("url=")], "url=") {
+		return "", false
+	}
+	target = strings.Trim(strings.TrimSpace(target[len("url="):]), `'"`)
+	if target == "" {
+		return "", false
+	}
+	return target, true
+}
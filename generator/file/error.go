@@ -0,0 +1,78 @@
+package file
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// Error is an error that occurred while processing a single file.
+// It annotates the underlying error with the location it occurred at, but does not change its message.
+type Error struct {
+	Path   string // Path of the file being produced, if any.
+	Source string // Slash-separated path of the source file, if any.
+
+	Template string // Name of the template the error occurred in, if any.
+	Line     int    // Line inside the template, or the source file if there is no template; zero if unknown.
+
+	Err error
+}
+
+// NewError wraps err into an [Error] describing the given file.
+// If err is nil, returns nil.
+//
+// If err was caused by executing or parsing a template, the location inside the template is recorded.
+func NewError(file File, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	fe := &Error{
+		Path:   file.Path,
+		Source: file.Origin.Source,
+		Err:    err,
+	}
+	if match := templateLocation.FindStringSubmatch(err.Error()); match != nil {
+		fe.Template = match[1]
+		fe.Line, _ = strconv.Atoi(match[2])
+	}
+	return fe
+}
+
+// templateLocation matches the location prefix of errors produced by text/template and html/template.
+var templateLocation = regexp.MustCompile(`template: ([^:\s]+):(\d+)`)
+
+func (fe *Error) Error() string {
+	return fe.Err.Error()
+}
+
+func (fe *Error) Unwrap() error {
+	return fe.Err
+}
+
+// Errors returns all errors of type [Error] found anywhere in the tree of err.
+// The tree consists of err itself, and the errors obtained by repeatedly calling its Unwrap method, as described in the errors package.
+func Errors(err error) []*Error {
+	var found []*Error
+
+	var walk func(err error)
+	walk = func(err error) {
+		if fe, ok := err.(*Error); ok {
+			found = append(found, fe)
+		}
+
+		switch wrapped := err.(type) {
+		case interface{ Unwrap() error }:
+			if inner := wrapped.Unwrap(); inner != nil {
+				walk(inner)
+			}
+		case interface{ Unwrap() []error }:
+			for _, inner := range wrapped.Unwrap() {
+				walk(inner)
+			}
+		}
+	}
+	if err != nil {
+		walk(err)
+	}
+	return found
+}
@@ -8,13 +8,34 @@ import (
 
 // File describes a single file.
 type File struct {
-	// Path is the relative path relative to some abitrary root directory to this file.
+	// Path is the slash-separated path to this file, relative to some abitrary root directory.
 	//
-	// Paths may start with ".." indicating behavior outside the root output directory.
-	// Consumers of a File should implement appropriate protections.
+	// The generator normalizes and validates paths before passing files to an output.
+	// Such paths are never absolute, never contain ".." elements, and never differ from another path only in case.
 	Path string
 
 	Contents []byte
+
+	// Origin describes where this file came from.
+	Origin Origin
+}
+
+// Origin describes where a file came from.
+type Origin struct {
+	Scanner string // Name of the scanner that produced the file, such as "markdown".
+	Source  string // Slash-separated path of the source file, if any.
+}
+
+// String returns a human readable description of this origin.
+func (origin Origin) String() string {
+	name := origin.Scanner
+	if name == "" {
+		name = "unknown"
+	}
+	if origin.Source == "" {
+		return name + " scanner"
+	}
+	return name + " scanner (" + origin.Source + ")"
 }
 
 // Body returns the contents of this file as unsafe html.
@@ -0,0 +1,423 @@
+//spellchecker:words generator
+package generator
+
+//spellchecker:words bytes context sha256 encoding errors slog maps path regexp slices strings golang html
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.tkw01536.de/blog/generator/file"
+
+	"golang.org/x/net/html"
+)
+
+// Fingerprint renames assets to include a hash of their contents, and rewrites all references to them.
+//
+// Assets are renamed from "name.ext" to "name.<hash>.ext", where hash is computed from the final contents of the file.
+// References in html attributes, inline styles and css "url()" expressions are rewritten to the new name.
+// When an asset references another asset, the referenced asset is fingerprinted first.
+//
+// Use [Fingerprint.Finalize] as a [Finalizer], and [Fingerprint.Asset] as a template function.
+type Fingerprint struct {
+	// Match determines if the file with the given output path is fingerprinted.
+	Match func(path string) bool
+}
+
+var errFingerprintCycle = errors.New("assets reference each other")
+
+// Finalize fingerprints all matched files and rewrites references to them.
+// It implements [Finalizer].
+func (fp *Fingerprint) Finalize(ctx context.Context, logger *slog.Logger, files []file.File) ([]file.File, error) {
+	assets := make(map[string]int) // path of each asset => index into files
+	for i, f := range files {
+		if fp.Match != nil && fp.Match(f.Path) {
+			assets[f.Path] = i
+		}
+	}
+
+	renamed := make(map[string]string, len(assets)) // original path => fingerprinted path
+	visiting := make(map[string]bool, len(assets))
+
+	// fingerprint fingerprints the asset at the given path.
+	var fingerprint func(name string) error
+	fingerprint = func(name string) error {
+		if _, ok := renamed[name]; ok {
+			return nil
+		}
+		if visiting[name] {
+			return fmt.Errorf("%w: %q", errFingerprintCycle, name)
+		}
+		visiting[name] = true
+
+		index := assets[name]
+		asset := files[index]
+
+		// fingerprint everything this asset depends on first
+		if isCSS(asset.Path) {
+			for _, ref := range cssReferences(asset.Contents) {
+				dep, ok := resolveReference(asset.Path, ref)
+				if _, isAsset := assets[dep]; ok && isAsset && dep != name {
+					if err := fingerprint(dep); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		contents, err := rewriteReferences(asset.Path, asset.Contents, renamed)
+		if err != nil {
+			return fmt.Errorf("failed to rewrite references in %q: %w", asset.Path, err)
+		}
+
+		hash := sha256.Sum256(contents)
+		ext := path.Ext(asset.Path)
+		newPath := strings.TrimSuffix(asset.Path, ext) + "." + hex.EncodeToString(hash[:8]) + ext
+
+		logger.Info("fingerprinted asset", slog.String("path", asset.Path), slog.String("fingerprinted", newPath))
+		renamed[name] = newPath
+		files[index] = file.File{Path: newPath, Contents: contents, Origin: asset.Origin}
+		return nil
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(assets)) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := fingerprint(name); err != nil {
+			return nil, err
+		}
+	}
+
+	// assets have been rewritten while fingerprinting, and no longer have their original path.
+	isAsset := make(map[int]bool, len(assets)) // index into files => is an asset
+	for _, index := range assets {
+		isAsset[index] = true
+	}
+
+	// rewrite all references in the other files
+	for i, f := range files {
+		if isAsset[i] {
+			continue
+		}
+
+		contents, err := rewriteReferences(f.Path, f.Contents, renamed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to rewrite references in %q: %w", f.Path, err)
+		}
+
+		contents, err = replaceAssetPlaceholders(contents, renamed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve assets in %q: %w", f.Path, err)
+		}
+
+		files[i] = file.File{Path: f.Path, Contents: contents, Origin: f.Origin}
+	}
+
+	return files, nil
+}
+
+// assetPlaceholderPrefix is the prefix of placeholders returned by [Fingerprint.Asset].
+// Placeholders only consist of characters that are never escaped by html/template, regardless of context.
+const (
+	assetPlaceholderPrefix = "__fingerprint_asset_"
+	assetPlaceholderSuffix = "__"
+)
+
+var assetPlaceholder = regexp.MustCompile(assetPlaceholderPrefix + `([0-9a-f]*)` + assetPlaceholderSuffix)
+
+// Asset resolves a logical asset path, such as "/styles/global.css", to its fingerprinted url.
+// It is intended to be used as a template function.
+//
+// Because fingerprints are only known once all files have been generated, Asset returns a placeholder.
+// The placeholder is replaced with the fingerprinted url by [Fingerprint.Finalize].
+// Referencing an asset that does not exist causes finalizing to fail.
+func (fp *Fingerprint) Asset(logical string) string {
+	return assetPlaceholderPrefix + hex.EncodeToString([]byte(logical)) + assetPlaceholderSuffix
+}
+
+var errUnknownAsset = errors.New("unknown asset")
+
+// replaceAssetPlaceholders replaces placeholders returned by [Fingerprint.Asset] with the fingerprinted url.
+func replaceAssetPlaceholders(contents []byte, renamed map[string]string) ([]byte, error) {
+	if !bytes.Contains(contents, []byte(assetPlaceholderPrefix)) {
+		return contents, nil
+	}
+
+	var err error
+	contents = assetPlaceholder.ReplaceAllFunc(contents, func(match []byte) []byte {
+		logical, decodeErr := hex.DecodeString(string(assetPlaceholder.FindSubmatch(match)[1]))
+		if decodeErr != nil {
+			err = errors.Join(err, decodeErr)
+			return match
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+string(logical)), "/")
+		target, ok := renamed[name]
+		if !ok {
+			err = errors.Join(err, fmt.Errorf("%w: %q", errUnknownAsset, logical))
+			return match
+		}
+		return []byte("/" + target)
+	})
+	return contents, err
+}
+
+// rewriteReferences rewrites references in the file with the given path to renamed files.
+// Only html and css files are rewritten, other contents are returned unchanged.
+func rewriteReferences(name string, contents []byte, renamed map[string]string) ([]byte, error) {
+	if len(renamed) == 0 {
+		return contents, nil
+	}
+
+	rewrite := func(ref string) string {
+		return rewriteReference(name, ref, renamed)
+	}
+
+	switch {
+	case isCSS(name):
+		return rewriteCSS(contents, rewrite), nil
+	case isHTML(name):
+		var out bytes.Buffer
+		if err := rewriteHTML(&out, bytes.NewReader(contents), rewrite); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	default:
+		return contents, nil
+	}
+}
+
+// rewriteReference rewrites a single reference found in the file with the given name.
+// If ref does not reference a renamed file, it is returned unchanged.
+func rewriteReference(name string, ref string, renamed map[string]string) string {
+	target, ok := resolveReference(name, ref)
+	if !ok {
+		return ref
+	}
+	newTarget, ok := renamed[target]
+	if !ok {
+		return ref
+	}
+
+	// only the last path segment changes, so keep the reference relative or absolute as it was.
+	u, _ := url.Parse(strings.TrimSpace(ref))
+	u.Path = path.Join(path.Dir(u.Path), path.Base(newTarget))
+	return u.String()
+}
+
+// resolveReference resolves a reference found in the file with the given name to the path of an output file.
+// Returns false if the reference does not refer to a local file.
+func resolveReference(name string, ref string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return "", false
+	}
+
+	target := u.Path
+	if !strings.HasPrefix(target, "/") {
+		target = path.Join(path.Dir("/"+name), target)
+	}
+	return strings.TrimPrefix(path.Clean(target), "/"), true
+}
+
+// cssURLs calls f with the start and end offsets of every url referenced using "url()" in the given css.
+//
+// This intentionally doesn't use a regular expression, as stylesheets may contain huge data urls.
+func cssURLs(contents []byte, f func(start, end int)) {
+	offset := 0
+	for {
+		index := bytes.Index(contents[offset:], []byte("url("))
+		if index < 0 {
+			return
+		}
+
+		start := offset + index + len("url(")
+		for start < len(contents) && isCSSSpace(contents[start]) {
+			start++
+		}
+		if start >= len(contents) {
+			return
+		}
+
+		// find the terminator of the url
+		terminator := byte(')')
+		if quote := contents[start]; quote == '"' || quote == '\'' {
+			terminator = quote
+			start++
+		}
+
+		length := bytes.IndexByte(contents[start:], terminator)
+		if length < 0 {
+			return
+		}
+		end := start + length
+		offset = end + 1
+
+		if terminator == ')' {
+			for end > start && isCSSSpace(contents[end-1]) {
+				end--
+			}
+		}
+		f(start, end)
+	}
+}
+
+func isCSSSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// cssReferences returns all urls referenced in the given css.
+func cssReferences(contents []byte) (refs []string) {
+	cssURLs(contents, func(start, end int) {
+		if bytes.HasPrefix(contents[start:end], []byte("data:")) {
+			return
+		}
+		refs = append(refs, string(contents[start:end]))
+	})
+	return refs
+}
+
+// rewriteCSS rewrites all urls in the given css using rewrite.
+func rewriteCSS(contents []byte, rewrite func(ref string) string) []byte {
+	var (
+		out  []byte
+		last int
+	)
+	cssURLs(contents, func(start, end int) {
+		if bytes.HasPrefix(contents[start:end], []byte("data:")) {
+			return
+		}
+		ref := string(contents[start:end])
+
+		newRef := rewrite(ref)
+		if newRef == ref {
+			return
+		}
+
+		out = append(out, contents[last:start]...)
+		out = append(out, newRef...)
+		last = end
+	})
+
+	if out == nil {
+		return contents
+	}
+	return append(out, contents[last:]...)
+}
+
+// urlAttributes are html attributes that hold a single url.
+var urlAttributes = map[string]bool{
+	"href":   true,
+	"src":    true,
+	"poster": true,
+	"data":   true,
+	"action": true,
+}
+
+// rewriteHTML rewrites all urls in attributes, inline styles and style elements using rewrite.
+func rewriteHTML(dst io.Writer, src io.Reader, rewrite func(ref string) string) error {
+	inStyle := false
+
+	tokenizer := html.NewTokenizer(src)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			err := tokenizer.Err()
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to parse html: %w", err)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			// Token lower-cases names within the buffer returned by Raw, so copy it first
+			raw := bytes.Clone(tokenizer.Raw())
+			token := tokenizer.Token()
+			inStyle = token.Data == "style" && token.Type == html.StartTagToken
+
+			changed := false
+			for i, attr := range token.Attr {
+				var value string
+				switch {
+				case urlAttributes[attr.Key]:
+					value = rewrite(attr.Val)
+				case attr.Key == "srcset":
+					value = rewriteSrcset(attr.Val, rewrite)
+				case attr.Key == "style":
+					value = string(rewriteCSS([]byte(attr.Val), rewrite))
+				default:
+					continue
+				}
+				if value != attr.Val {
+					token.Attr[i].Val = value
+					changed = true
+				}
+			}
+
+			if !changed {
+				if _, err := dst.Write(raw); err != nil {
+					return fmt.Errorf("failed to write out token: %w", err)
+				}
+				continue
+			}
+			if _, err := io.WriteString(dst, token.String()); err != nil {
+				return fmt.Errorf("failed to write out modified token: %w", err)
+			}
+		case html.TextToken:
+			raw := tokenizer.Raw()
+			if inStyle {
+				raw = rewriteCSS(raw, rewrite)
+			}
+			if _, err := dst.Write(raw); err != nil {
+				return fmt.Errorf("failed to write out token: %w", err)
+			}
+		default:
+			inStyle = false
+			if _, err := dst.Write(tokenizer.Raw()); err != nil {
+				return fmt.Errorf("failed to write out token: %w", err)
+			}
+		}
+	}
+}
+
+// rewriteSrcset rewrites the urls inside a "srcset" attribute value using rewrite.
+func rewriteSrcset(srcset string, rewrite func(ref string) string) string {
+	changed := false
+
+	candidates := strings.Split(srcset, ",")
+	for i, candidate := range candidates {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		if ref := rewrite(fields[0]); ref != fields[0] {
+			fields[0] = ref
+			changed = true
+		}
+		candidates[i] = strings.Join(fields, " ")
+	}
+
+	if !changed {
+		return srcset
+	}
+	return strings.Join(candidates, ", ")
+}
+
+func isCSS(name string) bool {
+	return strings.EqualFold(path.Ext(name), ".css")
+}
+
+func isHTML(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".html" || ext == ".htm"
+}
@@ -0,0 +1,280 @@
+//spellchecker:words generator
+package generator
+
+//spellchecker:words context sha256 encoding errors slog path strings testing srcset woff
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"path"
+	"strings"
+	"testing"
+
+	"go.tkw01536.de/blog/generator/file"
+)
+
+// testRenamed maps the original to the fingerprinted paths of assets used in tests.
+var testRenamed = map[string]string{
+	"style.css":       "style.1111.css",
+	"img/logo.png":    "img/logo.2222.png",
+	"img/logo-2x.png": "img/logo-2x.3333.png",
+}
+
+func TestRewriteCSS(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"unquoted", `a{background:url(img/logo.png)}`, `a{background:url(img/logo.2222.png)}`},
+		{"single quoted", `a{background:url('img/logo.png')}`, `a{background:url('img/logo.2222.png')}`},
+		{"double quoted", `a{background:url("img/logo.png")}`, `a{background:url("img/logo.2222.png")}`},
+		{"unquoted with spaces", `a{background:url(  img/logo.png	)}`, `a{background:url(  img/logo.2222.png	)}`},
+		{"quoted with spaces", `a{background:url( "img/logo.png" )}`, `a{background:url( "img/logo.2222.png" )}`},
+		{"absolute", `a{background:url(/img/logo.png)}`, `a{background:url(/img/logo.2222.png)}`},
+		{"query and fragment", `a{background:url(img/logo.png?v=1#top)}`, `a{background:url(img/logo.2222.png?v=1#top)}`},
+		{"several", `a{background:url(img/logo.png)}b{background:url("img/logo-2x.png")}`, `a{background:url(img/logo.2222.png)}b{background:url("img/logo-2x.3333.png")}`},
+		{"data url", `a{background:url(data:image/png;base64,aW1nL2xvZ28ucG5n)}`, `a{background:url(data:image/png;base64,aW1nL2xvZ28ucG5n)}`},
+		{"data url and asset", `a{background:url("data:image/svg+xml,<svg/>")}b{background:url(img/logo.png)}`, `a{background:url("data:image/svg+xml,<svg/>")}b{background:url(img/logo.2222.png)}`},
+		{"remote", `a{background:url(https://example.com/img/logo.png)}`, `a{background:url(https://example.com/img/logo.png)}`},
+		{"unknown", `a{background:url(img/other.png)}`, `a{background:url(img/other.png)}`},
+		{"unterminated", `a{background:url("img/logo.png)}`, `a{background:url("img/logo.png)}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := rewriteReferences("style.css", []byte(tt.in), testRenamed)
+			if err != nil {
+				t.Fatalf("rewriteReferences() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("rewriteReferences() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteHTML(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		path string
+		in   string
+		want string
+	}{
+		{
+			name: "relative",
+			path: "index.html",
+			in:   `<link rel="stylesheet" href="style.css">`,
+			want: `<link rel="stylesheet" href="style.1111.css">`,
+		},
+		{
+			name: "relative in sub directory",
+			path: "posts/hello/index.html",
+			in:   `<img src="../../img/logo.png" alt="logo">`,
+			want: `<img src="../../img/logo.2222.png" alt="logo">`,
+		},
+		{
+			name: "absolute",
+			path: "posts/hello/index.html",
+			in:   `<img src="/img/logo.png">`,
+			want: `<img src="/img/logo.2222.png">`,
+		},
+		{
+			name: "query and fragment",
+			path: "index.html",
+			in:   `<a href="/style.css?v=2#section">style</a>`,
+			want: `<a href="/style.1111.css?v=2#section">style</a>`,
+		},
+		{
+			name: "srcset",
+			path: "index.html",
+			in:   `<img srcset="img/logo.png 1x,img/logo-2x.png 2x, https://example.com/logo.png 3x">`,
+			want: `<img srcset="img/logo.2222.png 1x, img/logo-2x.3333.png 2x, https://example.com/logo.png 3x">`,
+		},
+		{
+			name: "inline style",
+			path: "index.html",
+			in:   `<div style="background: url('/img/logo.png')">x</div>`,
+			want: `<div style="background: url(&#39;/img/logo.2222.png&#39;)">x</div>`,
+		},
+		{
+			name: "style element",
+			path: "index.html",
+			in:   "<style>\nbody { background: url(img/logo.png) }\n</style><p>url(img/logo.png)</p>",
+			want: "<style>\nbody { background: url(img/logo.2222.png) }\n</style><p>url(img/logo.png)</p>",
+		},
+		{
+			name: "unchanged",
+			path: "index.html",
+			in:   "<!DOCTYPE html>\n<html lang=en><HEAD><link href='other.css' rel=stylesheet ></HEAD>\n<!-- url(style.css) --><body data-x = \"y\">\n</body></html>\n",
+			want: "<!DOCTYPE html>\n<html lang=en><HEAD><link href='other.css' rel=stylesheet ></HEAD>\n<!-- url(style.css) --><body data-x = \"y\">\n</body></html>\n",
+		},
+		{
+			name: "other file",
+			path: "feed.xml",
+			in:   `<link href="style.css"/>`,
+			want: `<link href="style.css"/>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := rewriteReferences(tt.path, []byte(tt.in), testRenamed)
+			if err != nil {
+				t.Fatalf("rewriteReferences() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("rewriteReferences() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteReferences_unchanged(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"index.html", "style.css", "script.js"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			in := []byte("<p style='color: red'>url(other.png) <img src=missing.png></p>")
+			got, err := rewriteReferences(name, in, testRenamed)
+			if err != nil {
+				t.Fatalf("rewriteReferences() error = %v", err)
+			}
+			if string(got) != string(in) {
+				t.Errorf("rewriteReferences() = %q, want unchanged %q", got, in)
+			}
+		})
+	}
+}
+
+func TestReplaceAssetPlaceholders(t *testing.T) {
+	t.Parallel()
+
+	var fp Fingerprint
+
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr error
+	}{
+		{"no placeholder", `<p>hello</p>`, `<p>hello</p>`, nil},
+		{"absolute", `<link href="` + fp.Asset("/style.css") + `">`, `<link href="/style.1111.css">`, nil},
+		{"relative", `<img src="` + fp.Asset("img/logo.png") + `">`, `<img src="/img/logo.2222.png">`, nil},
+		{"unclean", `<img src="` + fp.Asset("/img/../img/logo-2x.png") + `">`, `<img src="/img/logo-2x.3333.png">`, nil},
+		{"unknown", `<img src="` + fp.Asset("/missing.png") + `">`, "", errUnknownAsset},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := replaceAssetPlaceholders([]byte(tt.in), testRenamed)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("replaceAssetPlaceholders() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && string(got) != tt.want {
+				t.Errorf("replaceAssetPlaceholders() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fingerprinted returns the path an asset with the given path and contents is renamed to.
+func fingerprinted(name string, contents string) string {
+	hash := sha256.Sum256([]byte(contents))
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(hash[:8]) + ext
+}
+
+func TestFingerprint_Finalize(t *testing.T) {
+	t.Parallel()
+
+	fp := Fingerprint{Match: func(path string) bool { return !isHTML(path) }}
+
+	// the font is referenced by the stylesheet, and must be hashed first
+	font := "not really a font"
+	fontPath := fingerprinted("fonts/body.woff2", font)
+
+	style := `@font-face{src:url("../fonts/` + path.Base(fontPath) + `")}`
+	stylePath := fingerprinted("css/style.css", style)
+
+	files := []file.File{
+		{Path: "index.html", Contents: []byte(`<link href="/css/style.css"><script src="` + fp.Asset("app.js") + `"></script>`)},
+		{Path: "css/style.css", Contents: []byte(`@font-face{src:url("../fonts/body.woff2")}`)},
+		{Path: "app.js", Contents: []byte(`console.log("hi")`)},
+		{Path: "fonts/body.woff2", Contents: []byte(font)},
+	}
+
+	got, err := fp.Finalize(context.Background(), slog.New(slog.DiscardHandler), files)
+	if err != nil {
+		t.Fatalf("Finalize() error = %v", err)
+	}
+
+	scriptPath := fingerprinted("app.js", `console.log("hi")`)
+	want := []file.File{
+		{Path: "index.html", Contents: []byte(`<link href="/` + stylePath + `"><script src="/` + scriptPath + `"></script>`)},
+		{Path: stylePath, Contents: []byte(style)},
+		{Path: scriptPath, Contents: []byte(`console.log("hi")`)},
+		{Path: fontPath, Contents: []byte(font)},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Finalize() returned %d files, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Path != want[i].Path || string(got[i].Contents) != string(want[i].Contents) {
+			t.Errorf("Finalize() file %d = %q %q, want %q %q", i, got[i].Path, got[i].Contents, want[i].Path, want[i].Contents)
+		}
+	}
+}
+
+func TestFingerprint_Finalize_errors(t *testing.T) {
+	t.Parallel()
+
+	fp := Fingerprint{Match: isCSS}
+
+	tests := []struct {
+		name    string
+		files   []file.File
+		wantErr error
+	}{
+		{
+			name: "cycle",
+			files: []file.File{
+				{Path: "a.css", Contents: []byte(`@import url(b.css);`)},
+				{Path: "b.css", Contents: []byte(`@import url("/a.css");`)},
+			},
+			wantErr: errFingerprintCycle,
+		},
+		{
+			name: "unknown asset",
+			files: []file.File{
+				{Path: "index.html", Contents: []byte(`<link href="` + fp.Asset("missing.css") + `">`)},
+				{Path: "a.css", Contents: []byte(`a{}`)},
+			},
+			wantErr: errUnknownAsset,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := fp.Finalize(context.Background(), slog.New(slog.DiscardHandler), tt.files); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Finalize() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+//spellchecker:words generator
+package generator
+
+//spellchecker:words context slog maps path filepath slices strings sync
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.tkw01536.de/blog/generator/file"
+	"go.tkw01536.de/blog/generator/scanner"
+)
+
+// scanState remembers the results of scanning inputs between runs.
+// It allows Watch mode to only rescan sources that changed, see [scanner.PartialScanner].
+type scanState struct {
+	m sync.Mutex
+
+	enabled bool                       // remember results between runs, only set in Watch mode
+	results map[int][]file.ScannedFile // results of the last successful scan of each input
+
+	// changed holds cleaned file system paths that changed since all inputs were last scanned successfully.
+	// Each path maps to the sequence number of its latest change.
+	changed  map[string]uint64
+	sequence uint64
+}
+
+// enable makes runs remember scan results.
+func (state *scanState) enable() {
+	state.m.Lock()
+	defer state.m.Unlock()
+
+	state.enabled = true
+}
+
+// invalidate records that the given file system path changed.
+func (state *scanState) invalidate(path string) {
+	state.m.Lock()
+	defer state.m.Unlock()
+
+	if state.changed == nil {
+		state.changed = make(map[string]uint64)
+	}
+	state.sequence++
+	state.changed[filepath.Clean(path)] = state.sequence
+}
+
+// take returns the paths that changed since all inputs were last scanned successfully.
+// Paths are kept until they are passed to [scanState.done], so that they are scanned again when a run is cancelled.
+//
+// The second return value is the sequence number of the latest change, to be passed to done.
+// The third return value indicates if scan results are remembered at all.
+func (state *scanState) take() ([]string, uint64, bool) {
+	state.m.Lock()
+	defer state.m.Unlock()
+
+	return slices.Sorted(maps.Keys(state.changed)), state.sequence, state.enabled
+}
+
+// done records that all inputs were scanned successfully after the given paths changed.
+// sequence is the value returned by take, paths that changed again since then are kept.
+func (state *scanState) done(paths []string, sequence uint64) {
+	state.m.Lock()
+	defer state.m.Unlock()
+
+	for _, path := range paths {
+		if state.changed[path] <= sequence {
+			delete(state.changed, path)
+		}
+	}
+}
+
+// previous returns the results of the last successful scan of the input with the given index.
+func (state *scanState) previous(index int) ([]file.ScannedFile, bool) {
+	state.m.Lock()
+	defer state.m.Unlock()
+
+	results, ok := state.results[index]
+	return results, ok
+}
+
+// remember stores the results of scanning the input with the given index.
+func (state *scanState) remember(index int, results []file.ScannedFile) {
+	state.m.Lock()
+	defer state.m.Unlock()
+
+	if state.results == nil {
+		state.results = make(map[int][]file.ScannedFile)
+	}
+	state.results[index] = results
+}
+
+// forget discards the results of the input with the given index, so that it is scanned completely in the next run.
+func (state *scanState) forget(index int) {
+	state.m.Lock()
+	defer state.m.Unlock()
+
+	delete(state.results, index)
+}
+
+// scan scans the input with the given index, and sends the results to files.
+// changed holds the file system paths that changed since all inputs were last scanned successfully.
+//
+// Unless incremental is set, the input is scanned completely.
+// Otherwise results are remembered between runs, and only sources inside changed paths are rescanned.
+// Inputs not implementing [scanner.PartialScanner] are scanned completely when any of their paths changed.
+func (generator *Generator) scan(ctx context.Context, logger *slog.Logger, index int, changed []string, incremental bool, files chan<- file.ScannedFile) error {
+	input := generator.Inputs[index]
+	if !incremental {
+		return input.Scan(ctx, logger, files)
+	}
+
+	var relevant []string
+	for _, path := range changed {
+		if slices.ContainsFunc(input.Paths(), func(dir string) bool { return isInside(path, filepath.Clean(dir)) }) {
+			relevant = append(relevant, path)
+		}
+	}
+
+	var (
+		results []file.ScannedFile
+		rescan  func(files chan<- file.ScannedFile) error
+	)
+
+	previous, ok := generator.scans.previous(index)
+	partial, isPartial := input.(scanner.PartialScanner)
+	switch {
+	case ok && len(relevant) == 0:
+		logger.Info("reusing scanned files", slog.Int("input", index), slog.Int("count", len(previous)))
+		results = previous
+	case ok && isPartial:
+		logger.Info("rescanning changed paths", slog.Int("input", index), slog.Any("paths", relevant))
+
+		// drop everything that originated from a changed path, it is rescanned below
+		results = slices.DeleteFunc(slices.Clone(previous), func(f file.ScannedFile) bool {
+			source := filepath.FromSlash(f.Origin.Source)
+			return f.Origin.Source != "" && slices.ContainsFunc(relevant, func(path string) bool { return isInside(source, path) })
+		})
+		rescan = func(files chan<- file.ScannedFile) error {
+			return partial.ScanPaths(ctx, logger, relevant, files)
+		}
+	default:
+		rescan = func(files chan<- file.ScannedFile) error {
+			return input.Scan(ctx, logger, files)
+		}
+	}
+
+	for _, result := range results {
+		select {
+		case files <- result:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if rescan != nil {
+		scanned := make(chan file.ScannedFile)
+		errChan := make(chan error, 1)
+		go func() {
+			defer close(scanned)
+			errChan <- rescan(scanned)
+		}()
+
+		// once cancelled, keep draining until the scanner stops
+		for result := range scanned {
+			results = append(results, result)
+			if ctx.Err() != nil {
+				continue
+			}
+			select {
+			case files <- result:
+			case <-ctx.Done():
+			}
+		}
+
+		err := <-errChan
+		if ctx.Err() != nil {
+			// previous results stay valid, changed paths are scanned again in the next run
+			return ctx.Err()
+		}
+		if err != nil {
+			generator.scans.forget(index)
+			return err
+		}
+	}
+
+	generator.scans.remember(index, results)
+	return nil
+}
+
+// isInside checks if the given file system path is dir, or inside of it.
+// Both paths must be clean.
+func isInside(path, dir string) bool {
+	if dir == "." {
+		return !filepath.IsAbs(path)
+	}
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
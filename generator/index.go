@@ -12,6 +12,7 @@ import (
 	"slices"
 	"strings"
 
+	"go.tkw01536.de/blog/generator/cache"
 	"go.tkw01536.de/blog/generator/file"
 )
 
@@ -22,14 +23,32 @@ type IndexTemplate struct {
 
 	CompareFunc IndexComparisonFunc
 
-	Template *template.Template // Template to use for rendering.
-	Globals  map[string]any     // Global Metadata
-	Metadata map[string]any     // Metadata to return from the template.
+	Template TemplateSource // Template to use for rendering.
+	Globals  map[string]any // Global Metadata
+	Metadata map[string]any // Metadata to return from the template.
+
+	loaded  *template.Template // template loaded for the current run
+	version string             // version of loaded, see [TemplateSource]
+}
+
+// load loads the template for the current run.
+func (tpl *IndexTemplate) load() error {
+	loaded, version, err := tpl.Template.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load index template %q: %w", tpl.Path, err)
+	}
+	tpl.loaded, tpl.version = loaded, version
+	return nil
 }
 
 // Execute executes this index template.
 func (tpl *IndexTemplate) Execute(w io.Writer, entries []IndexEntry) error {
-	if err := tpl.Template.Execute(w, &IndexTemplateContext{
+	if tpl.loaded == nil {
+		if err := tpl.load(); err != nil {
+			return err
+		}
+	}
+	if err := tpl.loaded.Execute(w, &IndexTemplateContext{
 		Entries:  entries,
 		Template: tpl,
 	}); err != nil {
@@ -38,6 +57,18 @@ func (tpl *IndexTemplate) Execute(w io.Writer, entries []IndexEntry) error {
 	return nil
 }
 
+// key returns a cache key for rendering this template with the given entries.
+func (tpl *IndexTemplate) key(entries []IndexEntry) cache.Key {
+	return cache.NewKey(
+		"index",
+		[]byte(tpl.version),
+		[]byte(tpl.Path),
+		fmt.Appendf(nil, "%#v", tpl.Globals),
+		fmt.Appendf(nil, "%#v", tpl.Metadata),
+		fmt.Appendf(nil, "%#v", entries),
+	)
+}
+
 // IndexTemplateContext is passed to an index template.
 type IndexTemplateContext struct {
 	Entries  []IndexEntry
@@ -85,23 +116,35 @@ func (generator *Generator) renderIndexes(
 		logger.Info("sorting index", slog.Int("entryCount", len(entries)))
 		slices.SortFunc(entries, tpl.CompareFunc.f())
 
-		logger.Info("generating index content", slog.String("path", tpl.Path), slog.Int("entryCount", len(entries)))
-
-		var out bytes.Buffer
-		if err := tpl.Execute(&out, entries); err != nil {
-			return fmt.Errorf("failed to render index contents %q: %w", tpl.Path, err)
+		// indexes only need to be rendered again when any of their entries changed
+		contents, err := cache.Do(cache.FromContext(ctx), tpl.key(entries), func() ([]byte, error) {
+			logger.Info("generating index content", slog.String("path", tpl.Path), slog.Int("entryCount", len(entries)))
+
+			var out bytes.Buffer
+			if err := tpl.Execute(&out, entries); err != nil {
+				return nil, file.NewError(file.File{Path: tpl.Path, Origin: file.Origin{Scanner: "index"}}, fmt.Errorf("failed to render index contents %q: %w", tpl.Path, err))
+			}
+			return out.Bytes(), nil
+		})
+		if err != nil {
+			return err
 		}
 
-		output <- file.ScannedFile{
+		select {
+		case output <- file.ScannedFile{
 			FileWithMetadata: file.FileWithMetadata{
 				File: file.File{
 					Path:     tpl.Path,
-					Contents: out.Bytes(),
+					Contents: contents,
+					Origin:   file.Origin{Scanner: "index"},
 				},
 				Metadata: tpl.Metadata,
 			},
 			Indexed: false,
 			Raw:     tpl.Raw,
+		}:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
 	return nil
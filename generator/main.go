@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 
+	"go.tkw01536.de/blog/generator/cache"
 	"go.tkw01536.de/blog/generator/file"
 	"go.tkw01536.de/blog/generator/output"
 	"go.tkw01536.de/blog/generator/scanner"
@@ -32,8 +33,49 @@ type Generator struct {
 	// They are applied in order to each file being output.
 	PostProcessors []PostProcessor
 
+	// Finalizers are applied to the set of all post-processed files.
+	// They are applied in order, right before files are written to the output.
+	Finalizers []Finalizer
+
+	// Overrides lists output paths that are intentionally produced by more than one scanner.
+	// Each path maps to the name of the scanner whose file is kept, such as "markdown" or "redirect".
+	// Any other output path produced more than once fails the run.
+	Overrides map[string]string
+
 	// Output is used to write output files.
 	Output output.Output
+
+	// Cache is an optional on-disk cache shared between invocations of the generator.
+	// Scanners, post processors and finalizers use it via [cache.Persist] and [cache.FromContext].
+	// After a successful run, least recently used entries are evicted.
+	// Eviction walks the entire cache, so in watch mode it happens at most once per [evictInterval].
+	Cache *cache.Store
+
+	// WatchMethod determines how [Generator.Watch] detects changes.
+	WatchMethod WatchMethod
+
+	// PollInterval is the interval between polls when polling for changes, defaults to one second.
+	// See [WatchPoll].
+	PollInterval time.Duration
+
+	// ManifestPath is an optional path to write a [Manifest] of all output files to.
+	// The manifest is only written after a successful run.
+	ManifestPath string
+
+	// lastEvict is the time entries were last evicted from Cache.
+	lastEvict time.Time
+
+	// memo holds results of build steps from previous runs.
+	// It is created on the first run.
+	memo *cache.Cache
+
+	// postProcessors are the PostProcessors of the previous run, and postVersion identifies them.
+	// See [Generator.updatePostVersion].
+	postProcessors []PostProcessor
+	postVersion    uint64
+
+	// scans holds the results of scanning inputs in previous runs, see [Generator.Watch].
+	scans scanState
 }
 
 var errRecursiveIndex = errors.New("indexer produced file to be indexed: not allowed")
@@ -74,6 +116,10 @@ func debounce[T any](c <-chan T, window time.Duration, returnLast bool) <-chan T
 }
 
 // Run runs the static site generator with the given context, logging to the given logger.
+// Run must not be called concurrently.
+//
+// Results of build steps are cached between runs.
+// Steps are skipped when all of their inputs are unchanged since the previous run.
 //
 // If context is nil, uses a background context instead.
 // If logger is nil, discards all output.
@@ -86,7 +132,18 @@ func (generator *Generator) Run(ctx context.Context, logger *slog.Logger) error
 		logger = slog.New(slog.DiscardHandler)
 	}
 
-	ourContext, cancel := context.WithCancel(ctx)
+	report := new(Report)
+	defer report.log(logger)
+
+	if generator.memo == nil {
+		generator.memo = cache.New()
+	}
+	generator.memo.Store = generator.Cache
+	generator.updatePostVersion()
+
+	summary := newRunSummary()
+
+	ourContext, cancel := context.WithCancel(withSummary(withReport(cache.WithCache(ctx, generator.memo), report), summary))
 	errChan := make(chan error, 1)
 
 	// registerError registers an error and cancels the context
@@ -113,19 +170,29 @@ func (generator *Generator) Run(ctx context.Context, logger *slog.Logger) error
 		posts         = make(chan file.File, bufferSize) // outputs to be post-processed
 		postProducers sync.WaitGroup                     // waits for anything producing post-processing output
 
-		outputs         = make(chan file.File, bufferSize) // final outputs
-		outputProducers sync.WaitGroup                     // anything producing final output
+		outputs         = make(chan file.File, bufferSize) // post-processed outputs
+		outputProducers sync.WaitGroup                     // anything producing post-processed output
 
+		finals      = make(chan file.File, bufferSize) // final outputs
 		fileWriters sync.WaitGroup
+
+		manifests = make(chan *Manifest, 1)   // manifest of the final outputs
+		written   = make(chan []file.File, 1) // final outputs, for the summary
 	)
 
+	// templates are loaded once, so that all files of a run use the same ones
+	if err := generator.loadTemplates(); err != nil {
+		registerError(err)
+	}
+
 	// start all the inputs
-	for i, scanner := range generator.Inputs {
+	changed, sequence, incremental := generator.scans.take()
+	for i := range generator.Inputs {
 		inputProducers.Add(1)
 		go func() {
 			defer inputProducers.Done()
 
-			if err := scanner.Scan(ourContext, logger, inputs); err != nil {
+			if err := generator.scan(ourContext, logger, i, changed, incremental, inputs); err != nil {
 				registerError(fmt.Errorf("scanner %d failed to scan: %w", i, err))
 			}
 		}()
@@ -142,10 +209,16 @@ func (generator *Generator) Run(ctx context.Context, logger *slog.Logger) error
 
 		var indexed []IndexEntry
 		for result := range inputs {
-			if result.Raw {
-				posts <- result.File
-			} else {
-				contents <- result.FileWithMetadata
+			name, err := normalizePath(result.Path)
+			if err != nil {
+				registerError(fmt.Errorf("invalid path produced by %s: %w", result.Origin, err))
+				continue
+			}
+			result.Path = name
+			summary.scan(result)
+
+			if !forward(ourContext, posts, contents, result) {
+				continue
 			}
 
 			if result.Indexed {
@@ -165,34 +238,102 @@ func (generator *Generator) Run(ctx context.Context, logger *slog.Logger) error
 		defer contentProducers.Done()
 		defer postProducers.Done()
 
+		// keep draining index after errors, so that renderIndexes never blocks
 		for result := range index {
 			if result.Indexed {
 				registerError(errRecursiveIndex)
-				return
+				continue
 			}
-			if result.Raw {
-				posts <- result.File
-			} else {
-				contents <- result.FileWithMetadata
+
+			name, err := normalizePath(result.Path)
+			if err != nil {
+				registerError(fmt.Errorf("invalid path produced by %s: %w", result.Origin, err))
+				continue
 			}
+			result.Path = name
+			summary.scan(result)
+
+			forward(ourContext, posts, contents, result)
 		}
 	}()
 
-	// clear the output
+	// clear the output, the output is still finished if that fails
 	if err := generator.Output.Reset(); err != nil {
-		logger.Error("resetting output failed", slog.Any("error", err))
-		return err
+		registerError(fmt.Errorf("failed to reset output: %w", err))
 	}
 
-	// renderContent -> postProcess -> output
+	// renderContent -> postProcess -> finalize -> output
 	pipe(ourContext, logger, posts, contents, &postProducers, registerError, generator.renderFile)
 	pipe(ourContext, logger, outputs, posts, &outputProducers, registerError, generator.postProcess)
-	drain(ourContext, logger, outputs, &fileWriters, registerError, generator.Output.Write)
+	drain(ourContext, logger, finals, &fileWriters, registerError, generator.Output.Write)
+
+	// collect all outputs, and finalize them once all of them have been post-processed
+	var finalizing sync.WaitGroup
+	finalizing.Add(1)
+	go func() {
+		defer finalizing.Done()
+		defer close(finals)
+
+		var files []file.File
+		for result := range outputs {
+			files = append(files, result)
+		}
+
+		// don't bother finalizing if something went wrong
+		if ourContext.Err() != nil {
+			return
+		}
+
+		// check for collisions both before and after finalizers had a chance to rename files
+		files, err := generator.resolveCollisions(logger, files)
+		if err != nil {
+			registerError(err)
+			return
+		}
+
+		files, err = generator.finalize(ourContext, logger, files)
+		if err != nil {
+			registerError(fmt.Errorf("failed to finalize: %w", err))
+			return
+		}
+
+		files, err = generator.resolveCollisions(logger, files)
+		if err != nil {
+			registerError(fmt.Errorf("finalizers produced colliding files: %w", err))
+			return
+		}
+
+		// outputs may rely on validated paths
+		if err := validatePaths(files); err != nil {
+			registerError(err)
+			return
+		}
+		summary.step("finalize")
+
+		if generator.ManifestPath != "" {
+			manifests <- NewManifest(files)
+		}
+		written <- files
+
+		for _, result := range files {
+			select {
+			case finals <- result:
+			case <-ourContext.Done():
+				return
+			}
+		}
+	}()
 
 	// close all the components once done
 	go func() {
 		defer close(inputs)
 		inputProducers.Wait()
+		summary.step("scan")
+
+		// scanning failed or was cancelled otherwise
+		if ourContext.Err() == nil {
+			generator.scans.done(changed, sequence)
+		}
 	}()
 
 	go func() {
@@ -214,18 +355,107 @@ func (generator *Generator) Run(ctx context.Context, logger *slog.Logger) error
 	go func() {
 		defer close(outputs)
 		outputProducers.Wait()
+		summary.step("render")
 	}()
 
 	// wait for all the files to have been output
 	fileWriters.Wait()
+	summary.step("write")
 
-	// and show an error, if any
+	// when cancelled, writers stop early; wait for all other stages so that they never outlive the run
+	inputProducers.Wait()
+	indexProducers.Wait()
+	contentProducers.Wait()
+	postProducers.Wait()
+	outputProducers.Wait()
+	finalizing.Wait()
+
+	var err error
 	select {
-	case err := <-errChan:
+	case err = <-errChan:
+	default:
+	}
+	cancelled := err != nil && ctx.Err() != nil && errors.Is(err, context.Canceled)
+
+	// tell the output what happened
+	if summarizer, ok := generator.Output.(output.Summarizer); ok && !cancelled {
+		var files []file.File
+		select {
+		case files = <-written:
+		default:
+		}
+		summarizer.Summarize(summary.summary(files, report, err))
+	}
+
+	// tell the output that we're done
+	if errFinish := generator.Output.Finish(ctx, logger, err); errFinish != nil {
+		err = errors.Join(err, fmt.Errorf("failed to finish output: %w", errFinish))
+	}
+
+	// and show an error, if any
+	if cancelled {
+		logger.Info("build process cancelled")
+		return err
+	}
+	if err != nil {
 		logger.Error("build process failed", slog.Any("error", err))
 		return err
+	}
+
+	// write out the manifest, if any
+	select {
+	case manifest := <-manifests:
+		if err := manifest.WriteFile(generator.ManifestPath); err != nil {
+			logger.Error("failed to write manifest", slog.Any("error", err))
+			return err
+		}
+		logger.Info("wrote manifest", slog.String("path", generator.ManifestPath))
 	default:
-		return nil
+	}
+
+	// forget about everything that wasn't needed
+	kept, removed := generator.memo.Sweep()
+	logger.Info("swept cache", slog.Int("kept", kept), slog.Int("removed", removed))
+
+	generator.evict(logger)
+	return nil
+}
+
+// evictInterval is the minimal time between evicting entries from the on-disk cache.
+const evictInterval = 10 * time.Minute
+
+// evict evicts least recently used entries from the on-disk cache, unless that recently happened.
+func (generator *Generator) evict(logger *slog.Logger) {
+	if !generator.lastEvict.IsZero() && time.Since(generator.lastEvict) < evictInterval {
+		return
+	}
+	generator.lastEvict = time.Now()
+
+	evicted, err := generator.Cache.Evict()
+	if err != nil {
+		logger.Error("failed to evict cache entries", slog.Any("error", err))
+	} else if evicted > 0 {
+		logger.Info("evicted cache entries", slog.Int("count", evicted))
+	}
+}
+
+// forward sends a scanned file to posts if it is raw, and to contents otherwise.
+// It returns false if ctx was cancelled before the file could be sent.
+func forward(ctx context.Context, posts chan<- file.File, contents chan<- file.FileWithMetadata, result file.ScannedFile) bool {
+	if result.Raw {
+		select {
+		case posts <- result.File:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	select {
+	case contents <- result.FileWithMetadata:
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
 
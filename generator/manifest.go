@@ -0,0 +1,168 @@
+//spellchecker:words generator
+package generator
+
+//spellchecker:words sha256 json slog path slices strings
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go.tkw01536.de/blog/generator/file"
+)
+
+// Manifest describes every file produced by a single run of the generator.
+//
+// Manifests are stored as json, see [Manifest.WriteFile] and [ReadManifest].
+// Two manifests can be compared using [DiffManifests].
+type Manifest struct {
+	Files []ManifestEntry `json:"files"` // sorted by path
+}
+
+// ManifestEntry describes a single file inside a [Manifest].
+type ManifestEntry struct {
+	Path            string `json:"path"`
+	SHA256          string `json:"sha256"`
+	Size            int    `json:"size"`
+	ContentType     string `json:"contentType"`
+	ContentEncoding string `json:"contentEncoding,omitempty"` // encoding of precompressed variants
+	Source          string `json:"source,omitempty"`
+	Scanner         string `json:"scanner,omitempty"`
+}
+
+// NewManifest creates a new manifest describing the given files.
+func NewManifest(files []file.File) *Manifest {
+	manifest := &Manifest{Files: make([]ManifestEntry, 0, len(files))}
+	for _, f := range files {
+		sum := sha256.Sum256(f.Contents)
+		contentType, encoding := detectContentType(f.Path, f.Contents)
+
+		manifest.Files = append(manifest.Files, ManifestEntry{
+			Path:            f.Path,
+			SHA256:          hex.EncodeToString(sum[:]),
+			Size:            len(f.Contents),
+			ContentType:     contentType,
+			ContentEncoding: encoding,
+			Source:          f.Origin.Source,
+			Scanner:         f.Origin.Scanner,
+		})
+	}
+
+	slices.SortFunc(manifest.Files, func(left, right ManifestEntry) int {
+		return strings.Compare(left.Path, right.Path)
+	})
+	return manifest
+}
+
+// encodingExtensions maps extensions of precompressed variants to their encoding.
+var encodingExtensions = map[string]string{
+	".gz":  "gzip",
+	".zst": "zstd",
+}
+
+// detectContentType determines the content type of the file with the given path and contents.
+// For precompressed variants, it returns the content type of the original file along with the encoding.
+func detectContentType(name string, contents []byte) (contentType, encoding string) {
+	if enc, ok := encodingExtensions[path.Ext(name)]; ok {
+		encoding = enc
+		name = strings.TrimSuffix(name, path.Ext(name))
+		contents = nil // compressed contents say nothing about the original
+	}
+
+	return file.ContentType(name, contents), encoding
+}
+
+// ReadManifest reads a manifest from the given file.
+func ReadManifest(name string) (*Manifest, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// WriteFile writes this manifest to the given file.
+func (manifest *Manifest) WriteFile(name string) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	if dir := filepath.Dir(name); dir != "." {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+	}
+	if err := os.WriteFile(name, append(data, '\n'), 0666); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// ManifestChange is a single difference between two manifests.
+type ManifestChange struct {
+	Path     string
+	Old, New *ManifestEntry // nil when the file was added or removed respectively
+}
+
+// Kind returns "added", "removed" or "changed".
+func (change ManifestChange) Kind() string {
+	switch {
+	case change.Old == nil:
+		return "added"
+	case change.New == nil:
+		return "removed"
+	default:
+		return "changed"
+	}
+}
+
+// URL returns the url the changed file is served at.
+func (change ManifestChange) URL() string {
+	return file.File{Path: change.Path}.Link()
+}
+
+// DiffManifests returns the files that differ between the old and new manifest, sorted by path.
+// A file is considered changed when its contents, content type or encoding differ.
+func DiffManifests(old, new *Manifest) []ManifestChange {
+	index := func(manifest *Manifest) map[string]*ManifestEntry {
+		entries := make(map[string]*ManifestEntry, len(manifest.Files))
+		for i := range manifest.Files {
+			entries[manifest.Files[i].Path] = &manifest.Files[i]
+		}
+		return entries
+	}
+	oldEntries, newEntries := index(old), index(new)
+
+	var changes []ManifestChange
+	for name, oldEntry := range oldEntries {
+		newEntry, ok := newEntries[name]
+		switch {
+		case !ok:
+			changes = append(changes, ManifestChange{Path: name, Old: oldEntry})
+		case oldEntry.SHA256 != newEntry.SHA256 || oldEntry.ContentType != newEntry.ContentType || oldEntry.ContentEncoding != newEntry.ContentEncoding:
+			changes = append(changes, ManifestChange{Path: name, Old: oldEntry, New: newEntry})
+		}
+	}
+	for name, newEntry := range newEntries {
+		if _, ok := oldEntries[name]; !ok {
+			changes = append(changes, ManifestChange{Path: name, New: newEntry})
+		}
+	}
+
+	slices.SortFunc(changes, func(left, right ManifestChange) int {
+		return cmp.Compare(left.Path, right.Path)
+	})
+	return changes
+}
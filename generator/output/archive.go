@@ -0,0 +1,320 @@
+//spellchecker:words generator
+package output
+
+//spellchecker:words archive compress gzip context errors slog path filepath slices strings sync
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.tkw01536.de/blog/generator/file"
+)
+
+// ArchiveFormat is the format of an archive written by [Archive].
+type ArchiveFormat int
+
+const (
+	TarGzip ArchiveFormat = iota // a gzip-compressed tar archive
+	Zip                          // a zip archive
+)
+
+// Archive creates a new [Output] that writes all files into a single archive at the given path.
+//
+// Archives are reproducible: identical files always result in byte-identical archives.
+// To achieve this, entries are sorted by path, and have fixed timestamps and permissions.
+//
+// Files are kept in a temporary directory until generation has finished, and then streamed into the archive in order.
+// The archive is written once generation has succeeded, replacing any existing file at path.
+// When generation fails, path is left untouched.
+func Archive(path string, format ArchiveFormat) Output {
+	return &archiveWriter{
+		path:   path,
+		format: format,
+	}
+}
+
+// archiveTime is the modification time of all archive entries.
+// It is the earliest time representable in a zip archive.
+var archiveTime = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// permissions of archive entries
+const (
+	archiveFileMode = 0644
+	archiveDirMode  = 0755
+)
+
+var (
+	errArchivePath  = errors.New("path outside of archive")
+	errArchiveReset = errors.New("archive was not reset")
+)
+
+type archiveWriter struct {
+	m       sync.Mutex
+	files   map[string]int64 // sizes of files written since the last reset
+	temp    string           // temporary directory holding files written since the last reset
+	staging *os.Root         // root of temp
+
+	path   string
+	format ArchiveFormat
+}
+
+func (aw *archiveWriter) Reset() error {
+	aw.m.Lock()
+	defer aw.m.Unlock()
+
+	// remove files of an interrupted generation
+	if err := aw.removeTemp(); err != nil {
+		return err
+	}
+
+	temp, err := os.MkdirTemp("", "archive-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	staging, err := os.OpenRoot(temp)
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to open temporary directory: %w", err), os.RemoveAll(temp))
+	}
+
+	aw.files, aw.temp, aw.staging = make(map[string]int64), temp, staging
+	return nil
+}
+
+// removeTemp removes the temporary directory, if any.
+// The caller must hold aw.m.
+func (aw *archiveWriter) removeTemp() error {
+	if aw.staging == nil {
+		return nil
+	}
+
+	errClose := aw.staging.Close()
+	errRemove := os.RemoveAll(aw.temp)
+	aw.files, aw.temp, aw.staging = nil, "", nil
+
+	if err := errors.Join(errClose, errRemove); err != nil {
+		return fmt.Errorf("failed to remove temporary directory: %w", err)
+	}
+	return nil
+}
+
+func (aw *archiveWriter) Write(ctx context.Context, logger *slog.Logger, file file.File) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("context closed: %w", err)
+	}
+
+	name := path.Clean(filepath.ToSlash(file.Path))
+	if name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+		return fmt.Errorf("%w: %q", errArchivePath, file.Path)
+	}
+
+	aw.m.Lock()
+	staging := aw.staging
+	aw.m.Unlock()
+
+	if staging == nil {
+		return errArchiveReset
+	}
+
+	logger.Info("adding file to archive", slog.String("path", name), slog.Int("size", len(file.Contents)))
+	if err := staging.MkdirAll(path.Dir(name), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create temporary directory for %q: %w", name, err)
+	}
+	if err := staging.WriteFile(name, file.Contents, archiveFileMode); err != nil {
+		return fmt.Errorf("failed to write temporary file for %q: %w", name, err)
+	}
+
+	aw.m.Lock()
+	defer aw.m.Unlock()
+
+	if aw.files != nil {
+		aw.files[name] = int64(len(file.Contents))
+	}
+	return nil
+}
+
+func (aw *archiveWriter) Finish(ctx context.Context, logger *slog.Logger, buildErr error) (e error) {
+	aw.m.Lock()
+	defer aw.m.Unlock()
+
+	files, staging := aw.files, aw.staging
+	defer func() {
+		if err := aw.removeTemp(); err != nil {
+			e = errors.Join(e, err)
+		}
+	}()
+
+	if buildErr != nil {
+		logger.Info("not writing archive of failed generation", slog.String("path", aw.path))
+		return nil
+	}
+	if staging == nil {
+		return errArchiveReset
+	}
+
+	if err := os.MkdirAll(filepath.Dir(aw.path), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// write into a temporary file, so that a partial archive is never observed
+	temp, err := os.CreateTemp(filepath.Dir(aw.path), "."+filepath.Base(aw.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		if e != nil {
+			_ = os.Remove(temp.Name())
+		}
+	}()
+
+	var errWrite error
+	switch aw.format {
+	case TarGzip:
+		errWrite = writeTarGzip(ctx, temp, staging.FS(), files)
+	case Zip:
+		errWrite = writeZip(ctx, temp, staging.FS(), files)
+	default:
+		errWrite = fmt.Errorf("unknown archive format %d", aw.format)
+	}
+	errClose := temp.Close()
+	if err := errors.Join(errWrite, errClose); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	if err := os.Chmod(temp.Name(), archiveFileMode); err != nil {
+		return fmt.Errorf("failed to set archive permissions: %w", err)
+	}
+	if err := os.Rename(temp.Name(), aw.path); err != nil {
+		return fmt.Errorf("failed to rename archive: %w", err)
+	}
+
+	logger.Info("wrote archive", slog.String("path", aw.path), slog.Int("files", len(files)))
+	return nil
+}
+
+// archiveEntries returns the sorted names of all files and their parent directories.
+// Directory names end with a slash.
+func archiveEntries(files map[string]int64) []string {
+	entries := make(map[string]struct{}, len(files))
+	for name := range files {
+		entries[name] = struct{}{}
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			entries[dir+"/"] = struct{}{}
+		}
+	}
+	return slices.Sorted(maps.Keys(entries))
+}
+
+// copyEntry copies the contents of the file with the given name in fsys to w.
+func copyEntry(w io.Writer, fsys fs.FS, name string) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// writeTarGzip writes files, with contents read from fsys, as a reproducible gzip-compressed tar archive to w.
+func writeTarGzip(ctx context.Context, w io.Writer, fsys fs.FS, files map[string]int64) error {
+	// the gzip header holds neither name nor modification time
+	compressor, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if err != nil {
+		return fmt.Errorf("failed to create gzip writer: %w", err)
+	}
+	archive := tar.NewWriter(compressor)
+
+	for _, name := range archiveEntries(files) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header := &tar.Header{
+			Name:    name,
+			ModTime: archiveTime,
+			Format:  tar.FormatPAX,
+		}
+		size, isFile := files[name]
+		if isFile {
+			header.Typeflag = tar.TypeReg
+			header.Mode = archiveFileMode
+			header.Size = size
+		} else {
+			header.Typeflag = tar.TypeDir
+			header.Mode = archiveDirMode
+		}
+
+		if err := archive.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write header of %q: %w", name, err)
+		}
+		if !isFile {
+			continue
+		}
+		if err := copyEntry(archive, fsys, name); err != nil {
+			return fmt.Errorf("failed to write %q: %w", name, err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	if err := compressor.Close(); err != nil {
+		return fmt.Errorf("failed to close gzip writer: %w", err)
+	}
+	return nil
+}
+
+// writeZip writes files, with contents read from fsys, as a reproducible zip archive to w.
+func writeZip(ctx context.Context, w io.Writer, fsys fs.FS, files map[string]int64) error {
+	archive := zip.NewWriter(w)
+
+	for _, name := range archiveEntries(files) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header := &zip.FileHeader{
+			Name:     name,
+			Modified: archiveTime,
+		}
+		_, isFile := files[name]
+		if isFile {
+			header.Method = zip.Deflate
+			header.SetMode(archiveFileMode)
+		} else {
+			header.Method = zip.Store
+			header.SetMode(os.ModeDir | archiveDirMode)
+		}
+
+		writer, err := archive.CreateHeader(header)
+		if err != nil {
+			return fmt.Errorf("failed to write header of %q: %w", name, err)
+		}
+		if !isFile {
+			continue
+		}
+		if err := copyEntry(writer, fsys, name); err != nil {
+			return fmt.Errorf("failed to write %q: %w", name, err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to close zip writer: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+//spellchecker:words generator
+package output
+
+//spellchecker:words archive compress gzip bytes context errors slog path filepath slices sync testing
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"go.tkw01536.de/blog/generator/file"
+)
+
+// testArchiveFiles are the files written into archives by tests.
+var testArchiveFiles = []file.File{
+	{Path: "index.html", Contents: []byte("<p>home</p>")},
+	{Path: "posts/hello/index.html", Contents: []byte("<p>hello</p>")},
+	{Path: "posts/index.html", Contents: []byte("<p>posts</p>")},
+	{Path: "styles/site.css", Contents: []byte("body{margin:0}")},
+	{Path: "feed.xml", Contents: []byte("<rss/>")},
+}
+
+// archiveEntry is an entry read back from an archive.
+type archiveEntry struct {
+	name     string
+	mode     fs.FileMode
+	modTime  time.Time
+	contents string
+}
+
+// writeTestArchive writes files concurrently into an archive of the given format, and returns its contents.
+func writeTestArchive(t *testing.T, format ArchiveFormat, files []file.File) []byte {
+	t.Helper()
+
+	name := filepath.Join(t.TempDir(), "site.archive")
+	logger := slog.New(slog.DiscardHandler)
+
+	archive := Archive(name, format)
+	if err := archive.Reset(); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(files))
+	for i, f := range files {
+		wg.Go(func() {
+			errs[i] = archive.Write(context.Background(), logger, f)
+		})
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	if err := archive.Finish(context.Background(), logger, nil); err != nil {
+		t.Fatalf("Finish() error = %v", err)
+	}
+
+	contents, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+// readTarGzip reads all entries of a gzip-compressed tar archive.
+func readTarGzip(t *testing.T, contents []byte) (entries []archiveEntry) {
+	t.Helper()
+
+	decompressor, err := gzip.NewReader(bytes.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := tar.NewReader(decompressor)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, archiveEntry{name: header.Name, mode: header.FileInfo().Mode(), modTime: header.ModTime, contents: string(data)})
+	}
+}
+
+// readZip reads all entries of a zip archive.
+func readZip(t *testing.T, contents []byte) (entries []archiveEntry) {
+	t.Helper()
+
+	archive, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, archiveEntry{name: f.Name, mode: f.Mode(), modTime: f.Modified, contents: string(data)})
+	}
+	return entries
+}
+
+func TestArchive(t *testing.T) {
+	t.Parallel()
+
+	wantNames := []string{
+		"feed.xml",
+		"index.html",
+		"posts/",
+		"posts/hello/",
+		"posts/hello/index.html",
+		"posts/index.html",
+		"styles/",
+		"styles/site.css",
+	}
+
+	tests := []struct {
+		name   string
+		format ArchiveFormat
+		read   func(t *testing.T, contents []byte) []archiveEntry
+	}{
+		{"tar.gz", TarGzip, readTarGzip},
+		{"zip", Zip, readZip},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			reversed := slices.Clone(testArchiveFiles)
+			slices.Reverse(reversed)
+
+			first := writeTestArchive(t, tt.format, testArchiveFiles)
+			second := writeTestArchive(t, tt.format, reversed)
+			if !bytes.Equal(first, second) {
+				t.Error("archives of identical files differ")
+			}
+
+			entries := tt.read(t, first)
+
+			names := make([]string, len(entries))
+			for i, entry := range entries {
+				names[i] = entry.name
+			}
+			if !slices.Equal(names, wantNames) {
+				t.Fatalf("archive entries = %v, want %v", names, wantNames)
+			}
+
+			for _, entry := range entries {
+				if !entry.modTime.Equal(archiveTime) {
+					t.Errorf("entry %q modified at %v, want %v", entry.name, entry.modTime, archiveTime)
+				}
+
+				isDir := entry.name[len(entry.name)-1] == '/'
+				wantMode := fs.FileMode(archiveFileMode)
+				if isDir {
+					wantMode = fs.ModeDir | archiveDirMode
+				}
+				if entry.mode != wantMode {
+					t.Errorf("entry %q has mode %v, want %v", entry.name, entry.mode, wantMode)
+				}
+
+				if isDir {
+					continue
+				}
+				index := slices.IndexFunc(testArchiveFiles, func(f file.File) bool { return f.Path == entry.name })
+				if want := string(testArchiveFiles[index].Contents); entry.contents != want {
+					t.Errorf("entry %q = %q, want %q", entry.name, entry.contents, want)
+				}
+			}
+		})
+	}
+}
+
+func TestArchive_failed(t *testing.T) {
+	t.Parallel()
+
+	name := filepath.Join(t.TempDir(), "site.zip")
+	if err := os.WriteFile(name, []byte("previous"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.DiscardHandler)
+	archive := Archive(name, Zip)
+	if err := archive.Reset(); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	if err := archive.Write(context.Background(), logger, testArchiveFiles[0]); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := archive.Finish(context.Background(), logger, errors.New("build failed")); err != nil {
+		t.Fatalf("Finish() error = %v", err)
+	}
+
+	if contents, err := os.ReadFile(name); err != nil || string(contents) != "previous" {
+		t.Errorf("archive of failed build = %q, %v; want previous archive kept", contents, err)
+	}
+}
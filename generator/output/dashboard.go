@@ -0,0 +1,136 @@
+//spellchecker:words generator
+package output
+
+//spellchecker:words bytes html template http
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+
+	"go.tkw01536.de/blog/generator/file"
+)
+
+// dashboardPath is the path of the developer dashboard.
+const dashboardPath = "/_dev/"
+
+// dashboardFile is a single file shown on the dashboard.
+type dashboardFile struct {
+	FileSummary
+
+	Link  string // link to the file
+	Draft bool   // if the file is marked as draft by its metadata
+}
+
+// dashboard holds the data shown on the dashboard.
+type dashboard struct {
+	Build *Summary // last generation, if any
+
+	Served    bool            // if there is a generation being served
+	Files     []dashboardFile // files of the generation being served
+	Drafts    []dashboardFile // drafts among Files
+	Unindexed []dashboardFile // pages among Files produced from a source file, but not indexed, and not drafts
+}
+
+// newDashboard creates the dashboard showing the given generations.
+// served is the summary of the generation being served, lastBuild the summary of the last generation.
+func newDashboard(served, lastBuild *Summary) *dashboard {
+	d := &dashboard{Build: lastBuild, Served: served != nil}
+	if served == nil {
+		return d
+	}
+
+	for _, summary := range served.Files {
+		f := dashboardFile{
+			FileSummary: summary,
+			Link:        "/" + summary.Path,
+		}
+		if isPage(summary.Path) {
+			f.Link = file.File{Path: summary.Path}.Link()
+		}
+		f.Draft, _ = summary.Metadata["draft"].(bool)
+
+		d.Files = append(d.Files, f)
+		switch {
+		case f.Draft:
+			d.Drafts = append(d.Drafts, f)
+		case isPage(f.Path) && f.Origin.Source != "" && !f.Indexed:
+			d.Unindexed = append(d.Unindexed, f)
+		}
+	}
+	return d
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Dashboard</title>
+<style>
+body{font:14px/1.4 system-ui,sans-serif;margin:2em;color:#222}
+table{border-collapse:collapse}
+th,td{padding:.2em .6em;border-bottom:1px solid #ddd;text-align:left;vertical-align:top}
+.num{text-align:right;font-variant-numeric:tabular-nums}
+.failed{color:#b00}
+dl{margin:0}dt{font-weight:bold}dd{margin:0 0 .3em 1em}
+</style>
+</head>
+<body>
+<h1>Dashboard</h1>
+
+<h2>Last generation</h2>
+{{ with .Build }}
+<p>Started at {{ .Started.Format "15:04:05" }}, {{ if .Err }}<strong class="failed">failed</strong>{{ else }}succeeded{{ end }}.</p>
+{{ with .Err }}<pre class="failed">{{ . }}</pre>{{ end }}
+<table>
+<tr><th>Step</th><th class="num">Finished after</th></tr>
+{{ range .Steps }}<tr><td>{{ .Name }}</td><td class="num">{{ .Finished }}</td></tr>
+{{ end }}</table>
+
+<h2>Warnings ({{ len .Notes }})</h2>
+{{ if .Notes }}<table>
+<tr><th>Path</th><th>Source</th><th>Message</th></tr>
+{{ range .Notes }}<tr><td>{{ .Path }}</td><td>{{ .Source }}</td><td>{{ .Message }}</td></tr>
+{{ end }}</table>{{ else }}<p>None.</p>{{ end }}
+{{ else }}<p>No generation has finished yet.</p>{{ end }}
+
+{{ if .Served }}
+<h2>Drafts ({{ len .Drafts }})</h2>
+{{ template "list" .Drafts }}
+
+<h2>Unindexed pages ({{ len .Unindexed }})</h2>
+{{ template "list" .Unindexed }}
+
+<h2>Files ({{ len .Files }})</h2>
+<table>
+<tr><th>Path</th><th>Scanner</th><th>Source</th><th>Draft</th><th>Indexed</th><th class="num">Size</th><th class="num">Render time</th><th>Metadata</th></tr>
+{{ range .Files }}<tr><td><a href="{{ .Link }}">{{ .Path }}</a></td><td>{{ .Origin.Scanner }}</td><td>{{ .Origin.Source }}</td><td>{{ if .Draft }}yes{{ end }}</td><td>{{ if .Indexed }}yes{{ end }}</td><td class="num">{{ .Size }}</td><td class="num">{{ if .Rendered }}{{ if .RenderTime }}{{ .RenderTime }}{{ else }}cached{{ end }}{{ end }}</td><td>{{ with .Metadata }}<details><summary>{{ len . }} entries</summary><dl>{{ range $key, $value := . }}<dt>{{ $key }}</dt><dd>{{ $value }}</dd>{{ end }}</dl></details>{{ end }}</td></tr>
+{{ end }}</table>
+{{ else }}<p>No generation is being served yet.</p>{{ end }}
+</body>
+</html>
+{{ define "list" }}{{ if . }}<ul>{{ range . }}<li><a href="{{ .Link }}">{{ .Link }}</a>{{ with .Origin.Source }} from <code>{{ . }}</code>{{ end }}</li>{{ end }}</ul>{{ else }}<p>None.</p>{{ end }}{{ end }}`))
+
+// serveDashboard serves the developer dashboard.
+func (sw *serverWriter) serveDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sw.l.Lock()
+	served, lastBuild := sw.current.summary, sw.lastBuild
+	sw.l.Unlock()
+
+	var buffer bytes.Buffer
+	if err := dashboardTemplate.Execute(&buffer, newDashboard(served, lastBuild)); err != nil {
+		http.Error(w, "failed to render dashboard: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(injectSnippet(buffer.Bytes(), liveSnippet))
+	}
+}
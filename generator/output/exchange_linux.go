@@ -0,0 +1,19 @@
+//spellchecker:words generator
+package output
+
+//spellchecker:words errors golang unix renameat
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// exchangeDirectories atomically exchanges the directories at a and b.
+// It returns false if exchanging is not supported by the operating system or file system.
+func exchangeDirectories(a, b string) (bool, error) {
+	err := unix.Renameat2(unix.AT_FDCWD, a, unix.AT_FDCWD, b, unix.RENAME_EXCHANGE)
+	if errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOTSUP) {
+		return false, nil
+	}
+	return true, err
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+//spellchecker:words generator
+package output
+
+// exchangeDirectories atomically exchanges the directories at a and b.
+// It returns false if exchanging is not supported by the operating system or file system.
+func exchangeDirectories(a, b string) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,230 @@
+//spellchecker:words generator
+package output
+
+//spellchecker:words bytes rand embed http path strconv strings sync testing fstest golang
+import (
+	"bytes"
+	"crypto/rand"
+	_ "embed"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing/fstest"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// liveEventsPath is the path of the Server-Sent Events endpoint notifying clients about new builds.
+const liveEventsPath = "/_dev/events"
+
+//go:embed live.js
+var liveScript string
+
+// liveSnippet is injected into every html page.
+var liveSnippet = []byte("<script>" + liveScript + "</script>")
+
+// kinds of live events
+const (
+	liveReload = "reload" // the page needs to be reloaded
+	liveCSS    = "css"    // only stylesheets changed, and can be swapped without reloading
+)
+
+// liveEvent is sent to clients after a successful build.
+type liveEvent struct {
+	kind    string
+	version string
+}
+
+// liveReloader keeps track of connected live reload clients.
+type liveReloader struct {
+	m           sync.Mutex
+	prefix      string // random prefix, distinguishes versions of different processes
+	builds      int    // number of builds that changed something
+	subscribers map[chan liveEvent]struct{}
+}
+
+// version returns the current version.
+// The caller must hold lr.m.
+func (lr *liveReloader) version() string {
+	if lr.prefix == "" {
+		lr.prefix = rand.Text()[:8]
+	}
+	return lr.prefix + "-" + strconv.Itoa(lr.builds)
+}
+
+// notify sends an event of the given kind to all connected clients.
+func (lr *liveReloader) notify(kind string) {
+	lr.m.Lock()
+	defer lr.m.Unlock()
+
+	lr.builds++
+	event := liveEvent{kind: kind, version: lr.version()}
+	for subscriber := range lr.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			// client is not keeping up, it will see a new version when reconnecting
+		}
+	}
+}
+
+// subscribe registers a new client, and returns the current version.
+func (lr *liveReloader) subscribe() (chan liveEvent, string) {
+	lr.m.Lock()
+	defer lr.m.Unlock()
+
+	if lr.subscribers == nil {
+		lr.subscribers = make(map[chan liveEvent]struct{})
+	}
+
+	subscriber := make(chan liveEvent, 8)
+	lr.subscribers[subscriber] = struct{}{}
+	return subscriber, lr.version()
+}
+
+func (lr *liveReloader) unsubscribe(subscriber chan liveEvent) {
+	lr.m.Lock()
+	defer lr.m.Unlock()
+
+	delete(lr.subscribers, subscriber)
+}
+
+// liveKeepAlive is the interval between comments sent to idle clients.
+const liveKeepAlive = 15 * time.Second
+
+// ServeHTTP serves the Server-Sent Events endpoint.
+func (lr *liveReloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	subscriber, version := lr.subscribe()
+	defer lr.unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(kind, data string) bool {
+		_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", kind, data)
+		flusher.Flush()
+		return err == nil
+	}
+
+	// reconnect quickly when the server restarts
+	_, _ = io.WriteString(w, "retry: 1000\n")
+	if !send("hello", version) {
+		return
+	}
+
+	ticker := time.NewTicker(liveKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-subscriber:
+			if !send(event.kind, event.version) {
+				return
+			}
+		case <-ticker.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// liveChange determines how clients need to react to the change from the old to the new set of files.
+// It returns the kind of event to send, or the empty string if nothing changed.
+func liveChange(old, new fstest.MapFS) string {
+	names := maps.Clone(old)
+	maps.Copy(names, new)
+
+	changed := false
+	for name := range names {
+		// precompressed variants change together with the original
+		if ext := path.Ext(name); ext == ".gz" || ext == ".zst" {
+			continue
+		}
+
+		before, after := old[name], new[name]
+		if before != nil && after != nil && bytes.Equal(before.Data, after.Data) {
+			continue
+		}
+		changed = true
+
+		switch strings.ToLower(path.Ext(name)) {
+		case ".css":
+			continue
+		case ".html", ".htm":
+			// pages whose only change are references to stylesheets, such as renamed or rehashed ones
+			if before != nil && after != nil && bytes.Equal(withoutStylesheets(before.Data), withoutStylesheets(after.Data)) {
+				continue
+			}
+		}
+		return liveReload
+	}
+
+	if !changed {
+		return ""
+	}
+	return liveCSS
+}
+
+// withoutStylesheets returns the given html with all stylesheet links removed.
+func withoutStylesheets(data []byte) []byte {
+	var out bytes.Buffer
+
+	tokenizer := html.NewTokenizer(bytes.NewReader(data))
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			return out.Bytes()
+		}
+
+		raw := tokenizer.Raw()
+		if tt == html.StartTagToken || tt == html.SelfClosingTagToken {
+			token := tokenizer.Token()
+			if token.Data == "link" && isStylesheetLink(&token) {
+				continue
+			}
+		}
+		out.Write(raw)
+	}
+}
+
+// isStylesheetLink checks if the given link token refers to a stylesheet.
+func isStylesheetLink(token *html.Token) bool {
+	for _, attr := range token.Attr {
+		if attr.Key == "rel" && strings.Contains(strings.ToLower(attr.Val), "stylesheet") {
+			return true
+		}
+	}
+	return false
+}
+
+// injectSnippet returns a copy of the given html page with snippet injected at the end of the body.
+func injectSnippet(page []byte, snippet []byte) []byte {
+	index := bytes.LastIndex(bytes.ToLower(page), []byte("</body>"))
+	if index < 0 {
+		// minified pages may omit the closing tag
+		index = len(page)
+	}
+
+	result := make([]byte, 0, len(page)+len(snippet))
+	result = append(result, page[:index]...)
+	result = append(result, snippet...)
+	result = append(result, page[index:]...)
+	return result
+}
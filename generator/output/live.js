@@ -0,0 +1,71 @@
+// live reload client, injected into every html page served in watch mode
+(() => {
+    const scrollKey = "live-reload-scroll:" + location.pathname;
+
+    // restore the scroll position saved before reloading
+    const saved = sessionStorage.getItem(scrollKey);
+    if (saved !== null) {
+        sessionStorage.removeItem(scrollKey);
+        const [x, y] = JSON.parse(saved);
+        const restore = () => window.scrollTo(x, y);
+        if (document.readyState === "complete") {
+            restore();
+        } else {
+            window.addEventListener("load", restore, { once: true });
+        }
+    }
+
+    const reload = () => {
+        sessionStorage.setItem(scrollKey, JSON.stringify([window.scrollX, window.scrollY]));
+        location.reload();
+    };
+
+    // replace all stylesheets by those of the current version of this page
+    const swapStylesheets = async (version) => {
+        const response = await fetch(location.href, { cache: "no-store" });
+        if (!response.ok) {
+            reload();
+            return;
+        }
+
+        const selector = 'link[rel~="stylesheet"]';
+        const next = new DOMParser().parseFromString(await response.text(), "text/html");
+        const current = Array.from(document.querySelectorAll(selector));
+        const fresh = Array.from(next.querySelectorAll(selector));
+        if (current.length !== fresh.length) {
+            reload();
+            return;
+        }
+
+        current.forEach((link, i) => {
+            const replacement = document.importNode(fresh[i], true);
+
+            // bypass the browser cache when the name did not change
+            const href = new URL(replacement.href, location.href);
+            href.searchParams.set("live", version);
+            replacement.href = href.toString();
+
+            replacement.addEventListener("load", () => link.remove(), { once: true });
+            replacement.addEventListener("error", reload, { once: true });
+            link.after(replacement);
+        });
+    };
+
+    let version;
+    const events = new EventSource("/_dev/events");
+    events.addEventListener("hello", (event) => {
+        // the server restarted or rebuilt while we were disconnected
+        if (version !== undefined && version !== event.data) {
+            reload();
+        }
+        version = event.data;
+    });
+    events.addEventListener("reload", (event) => {
+        version = event.data;
+        reload();
+    });
+    events.addEventListener("css", (event) => {
+        version = event.data;
+        swapStylesheets(event.data).catch(reload);
+    });
+})();
@@ -1,9 +1,11 @@
 package output
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
@@ -18,6 +20,7 @@ import (
 //
 // Files outside the given directory are not tracked.
 // If cleanFirst is set to true, it cleans all files when first invoked.
+// Files that already exist with identical contents are not rewritten.
 func Native(path string, cleanFirst bool) Output {
 	return &nativeWriter{
 		path:       path,
@@ -25,14 +28,99 @@ func Native(path string, cleanFirst bool) Output {
 	}
 }
 
+// NativeAtomic creates a new [Output] that atomically replaces the directory at path with each successful generation.
+//
+// Files are written into a staging directory next to path.
+// Files with contents identical to those in path are hard linked instead, preserving their modification times.
+// Once generation has succeeded, the staging directory is swapped in using a rename.
+// The previously generated directory is kept next to path as a rollback copy, see [PreviousPath].
+// When generation fails, the staging directory is removed, and path is left untouched.
+func NativeAtomic(path string) Output {
+	return &nativeWriter{
+		path: path,
+		mode: nativeAtomic,
+	}
+}
+
+// NativeSync creates a new [Output] that keeps the directory at path in sync with each successful generation.
+//
+// Files that already exist with identical contents are left untouched, preserving their modification times.
+// Once generation has succeeded, files in path that were not written during the generation are deleted.
+// When generation fails, no files are deleted.
+//
+// The number of created, updated, unchanged and deleted files is logged at the end of each generation.
+func NativeSync(path string) Output {
+	return &nativeWriter{
+		path: path,
+		mode: nativeSync,
+	}
+}
+
+// StagingPath returns the path of the staging directory used by [NativeAtomic] for the given path.
+func StagingPath(path string) string {
+	return siblingPath(path, "staging")
+}
+
+// PreviousPath returns the path of the rollback copy kept by [NativeAtomic] for the given path.
+func PreviousPath(path string) string {
+	return siblingPath(path, "previous")
+}
+
+// siblingPath returns a hidden path next to path with the given suffix.
+func siblingPath(path string, suffix string) string {
+	path = filepath.Clean(path)
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"."+suffix)
+}
+
+// nativeMode determines how a [nativeWriter] updates its directory.
+type nativeMode int
+
+const (
+	nativePlain  nativeMode = iota // write directly into the directory
+	nativeAtomic                   // write into a staging directory, and swap it in on success
+	nativeSync                     // write directly into the directory, and delete stale files on success
+)
+
 type nativeWriter struct {
 	loaded atomic.Bool
 
 	m    sync.Mutex
 	root *os.Root
 
+	parent *os.Root // parent of path, holding both path and the staging directory in atomic mode
+
+	written map[string]struct{} // paths written since the last reset
+	stats   NativeStats         // statistics since the last reset
+
 	path       string
 	cleanFirst bool
+	mode       nativeMode
+}
+
+// NativeStats counts files handled by a native [Output] during a single generation.
+type NativeStats struct {
+	Created   int // files that did not exist before
+	Updated   int // files whose contents changed
+	Unchanged int // files that already had identical contents
+	Deleted   int // stale files that were removed
+}
+
+// LogValue implements [slog.LogValuer].
+func (stats NativeStats) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("created", stats.Created),
+		slog.Int("updated", stats.Updated),
+		slog.Int("unchanged", stats.Unchanged),
+		slog.Int("deleted", stats.Deleted),
+	)
+}
+
+// dir returns the directory files are being written to.
+func (nfw *nativeWriter) dir() string {
+	if nfw.mode == nativeAtomic {
+		return StagingPath(nfw.path)
+	}
+	return nfw.path
 }
 
 func (nfw *nativeWriter) openRoot(logger *slog.Logger) (*os.Root, error) {
@@ -52,7 +140,7 @@ func (nfw *nativeWriter) openRootSlow(logger *slog.Logger) (*os.Root, error) {
 		return nfw.root, nil
 	}
 
-	root, err := openRoot(logger, nfw.path, nfw.cleanFirst)
+	root, err := openRoot(logger, nfw.dir(), nfw.cleanFirst)
 	if err != nil {
 		return nil, fmt.Errorf("openRoot: %w", err)
 	}
@@ -62,7 +150,172 @@ func (nfw *nativeWriter) openRootSlow(logger *slog.Logger) (*os.Root, error) {
 	return nfw.root, nil
 }
 
+// closeRoot closes the currently open roots, if any.
+// The caller must hold nfw.m.
+func (nfw *nativeWriter) closeRoot() error {
+	var errRoot, errParent error
+	if nfw.root != nil {
+		errRoot = nfw.root.Close()
+		nfw.root = nil
+		nfw.loaded.Store(false)
+	}
+	if nfw.parent != nil {
+		errParent = nfw.parent.Close()
+		nfw.parent = nil
+	}
+
+	if err := errors.Join(errRoot, errParent); err != nil {
+		return fmt.Errorf("failed to close root: %w", err)
+	}
+	return nil
+}
+
 func (nfw *nativeWriter) Reset() error {
+	nfw.m.Lock()
+	defer nfw.m.Unlock()
+
+	nfw.written = make(map[string]struct{})
+	nfw.stats = NativeStats{}
+
+	if nfw.mode != nativeAtomic {
+		return nil
+	}
+
+	if err := nfw.closeRoot(); err != nil {
+		return err
+	}
+
+	// remove anything left over from an interrupted generation
+	if err := os.RemoveAll(nfw.dir()); err != nil {
+		return fmt.Errorf("failed to remove staging directory: %w", err)
+	}
+
+	parent := filepath.Dir(filepath.Clean(nfw.path))
+	if err := os.MkdirAll(parent, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
+	}
+	root, err := os.OpenRoot(parent)
+	if err != nil {
+		return fmt.Errorf("failed to open parent directory: %w", err)
+	}
+	nfw.parent = root
+	return nil
+}
+
+func (nfw *nativeWriter) Finish(ctx context.Context, logger *slog.Logger, buildErr error) error {
+	nfw.m.Lock()
+	defer nfw.m.Unlock()
+
+	switch nfw.mode {
+	case nativeAtomic:
+		if err := nfw.finishAtomic(logger, buildErr); err != nil {
+			return err
+		}
+	case nativeSync:
+		if err := nfw.finishSync(ctx, logger, buildErr); err != nil {
+			return err
+		}
+	}
+
+	logger.Info("finished writing files", slog.String("path", nfw.path), slog.Any("files", nfw.stats))
+	return nil
+}
+
+// finishAtomic swaps in the staging directory if buildErr is nil, and discards it otherwise.
+// The caller must hold nfw.m.
+func (nfw *nativeWriter) finishAtomic(logger *slog.Logger, buildErr error) error {
+	if err := nfw.closeRoot(); err != nil {
+		return err
+	}
+
+	staging := nfw.dir()
+	if buildErr != nil {
+		logger.Info("discarding staging directory", slog.String("path", staging))
+		if err := os.RemoveAll(staging); err != nil {
+			return fmt.Errorf("failed to remove staging directory: %w", err)
+		}
+		return nil
+	}
+
+	// an empty generation never created the directory
+	if err := os.MkdirAll(staging, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	previous := PreviousPath(nfw.path)
+	if err := os.RemoveAll(previous); err != nil {
+		return fmt.Errorf("failed to remove previous directory: %w", err)
+	}
+
+	logger.Info("swapping in staging directory", slog.String("path", nfw.path), slog.String("staging", staging), slog.String("previous", previous))
+	if err := replaceDirectory(nfw.path, staging, previous); err != nil {
+		return fmt.Errorf("failed to swap in staging directory: %w", err)
+	}
+	return nil
+}
+
+// finishSync removes all files that were not written since the last reset if buildErr is nil.
+// Directories left empty are removed as well.
+// The caller must hold nfw.m.
+func (nfw *nativeWriter) finishSync(ctx context.Context, logger *slog.Logger, buildErr error) error {
+	if buildErr != nil {
+		logger.Info("not removing stale files of failed generation", slog.String("path", nfw.path))
+		return nil
+	}
+
+	// nothing was written, but stale files may still exist
+	if nfw.root == nil {
+		root, err := openRoot(logger, nfw.path, false)
+		if err != nil {
+			return fmt.Errorf("failed to open root directory: %w", err)
+		}
+		nfw.root = root
+		nfw.loaded.Store(true)
+	}
+
+	var (
+		stale []string // stale files
+		dirs  []string // directories, parents before children
+	)
+	if err := fs.WalkDir(nfw.root.FS(), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if name != "." {
+				dirs = append(dirs, name)
+			}
+			return nil
+		}
+
+		if _, ok := nfw.written[name]; !ok {
+			stale = append(stale, name)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to list files: %w", err)
+	}
+
+	for _, name := range stale {
+		logger.Info("removing stale file", slog.String("path", name))
+		if err := nfw.root.Remove(filepath.FromSlash(name)); err != nil {
+			return fmt.Errorf("failed to remove stale file: %w", err)
+		}
+		nfw.stats.Deleted++
+	}
+
+	// remove directories left empty, children before parents
+	for _, name := range slices.Backward(dirs) {
+		err := nfw.root.Remove(filepath.FromSlash(name))
+		if err == nil {
+			logger.Info("removed empty directory", slog.String("path", name))
+		}
+	}
+
 	return nil
 }
 
@@ -84,6 +337,22 @@ func (nfw *nativeWriter) Write(ctx context.Context, logger *slog.Logger, file fi
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
+	if nfw.mode == nativeAtomic {
+		status, linked := nfw.linkUnchanged(logger, path, file.Contents)
+		nfw.record(path, status)
+		if linked {
+			logger.Info("linked unchanged file", slog.String("path", path))
+			return nil
+		}
+	} else {
+		status := compareFile(root, path, file.Contents)
+		nfw.record(path, status)
+		if status == fileUnchanged {
+			logger.Info("skipping unchanged file", slog.String("path", path))
+			return nil
+		}
+	}
+
 	logger.Info("writing file", slog.String("path", path), slog.Int("size", len(file.Contents)))
 	handle, err := root.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.ModePerm)
 	if err != nil {
@@ -109,6 +378,106 @@ func (nfw *nativeWriter) Write(ctx context.Context, logger *slog.Logger, file fi
 	return nil
 }
 
+// linkUnchanged hard links the file at path from the directory being replaced into the staging directory, if it has identical contents.
+// It returns the status of the file compared to the directory being replaced, and if it was linked.
+// Only used in atomic mode.
+func (nfw *nativeWriter) linkUnchanged(logger *slog.Logger, path string, contents []byte) (status fileStatus, linked bool) {
+	nfw.m.Lock()
+	parent := nfw.parent
+	nfw.m.Unlock()
+
+	if parent == nil {
+		return fileCreated, false
+	}
+
+	current := filepath.Join(filepath.Base(filepath.Clean(nfw.path)), path)
+	status = compareFile(parent, current, contents)
+	if status != fileUnchanged {
+		return status, false
+	}
+
+	// linking may fail on some file systems, in which case the file is simply written
+	if err := parent.Link(current, filepath.Join(filepath.Base(nfw.dir()), path)); err != nil {
+		logger.Warn("failed to link unchanged file", slog.String("path", path), slog.Any("error", err))
+		return status, false
+	}
+	return status, true
+}
+
+// record records that the file at path is being written with the given status.
+func (nfw *nativeWriter) record(path string, status fileStatus) {
+	nfw.m.Lock()
+	defer nfw.m.Unlock()
+
+	if nfw.written != nil {
+		nfw.written[filepath.ToSlash(filepath.Clean(path))] = struct{}{}
+	}
+
+	switch status {
+	case fileCreated:
+		nfw.stats.Created++
+	case fileUpdated:
+		nfw.stats.Updated++
+	case fileUnchanged:
+		nfw.stats.Unchanged++
+	}
+}
+
+// fileStatus describes how a file being written relates to the file that already exists.
+type fileStatus int
+
+const (
+	fileCreated   fileStatus = iota // no file existed
+	fileUpdated                     // a file with different contents existed
+	fileUnchanged                   // a file with identical contents existed
+)
+
+// compareFile compares the file at path in root with the given contents.
+func compareFile(root *os.Root, path string, contents []byte) fileStatus {
+	info, err := root.Stat(path)
+	if err != nil {
+		return fileCreated
+	}
+	if !info.Mode().IsRegular() || info.Size() != int64(len(contents)) {
+		return fileUpdated
+	}
+
+	existing, err := root.ReadFile(path)
+	if err != nil || !bytes.Equal(existing, contents) {
+		return fileUpdated
+	}
+	return fileUnchanged
+}
+
+// replaceDirectory replaces the directory at path with the directory at staging.
+// The old directory at path, if any, is moved to previous, which must not exist.
+//
+// If the platform supports it, path is replaced atomically.
+// Otherwise, there is a short moment where path does not exist.
+// In either case, path never contains a mix of old and new files.
+func replaceDirectory(path, staging, previous string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return os.Rename(staging, path)
+	}
+
+	// try to exchange both directories, and then move the old one out of the way
+	if exchanged, err := exchangeDirectories(staging, path); exchanged {
+		if err != nil {
+			return err
+		}
+		return os.Rename(staging, previous)
+	}
+
+	if err := os.Rename(path, previous); err != nil {
+		return err
+	}
+	if err := os.Rename(staging, path); err != nil {
+		// put the old directory back in place
+		return errors.Join(err, os.Rename(previous, path))
+	}
+	return nil
+}
+
 // openRoot is like [os.OpenRoot], except that it possibly creates path if it doesn't exist, and optionally removes any existing files in it.
 func openRoot(logger *slog.Logger, path string, clean bool) (*os.Root, error) {
 	root, err := os.OpenRoot(path)
@@ -16,9 +16,20 @@ import (
 type Output interface {
 	// Write writes the given file into the output.
 	// Write may be called concurrently.
+	//
+	// The path of the file has been validated by the generator, see [file.File].
+	// Outputs should nonetheless refuse paths escaping their destination.
 	Write(ctx context.Context, logger *slog.Logger, file file.File) error
 
 	// Reset is invoked right before the first file is written.
 	// It may be used to initialize the output, or to reset it when a new generation occurs in Watch mode.
 	Reset() error
+
+	// Finish is invoked once all files have been written, or generation has failed.
+	// buildErr is the error generation failed with, or nil if it succeeded.
+	// When generation was cancelled, for instance because a newer one superseded it in Watch mode, buildErr wraps [context.Canceled].
+	//
+	// Outputs may use it to commit or discard the files written since the last call to Reset.
+	// If Finish returns an error, generation is considered failed.
+	Finish(ctx context.Context, logger *slog.Logger, buildErr error) error
 }
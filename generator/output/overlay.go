@@ -0,0 +1,82 @@
+//spellchecker:words generator
+package output
+
+//spellchecker:words bytes html template strings
+import (
+	"bytes"
+	"html/template"
+	"strings"
+
+	"go.tkw01536.de/blog/generator/file"
+)
+
+// errorNode is a single error inside an error chain, as shown in the error overlay.
+type errorNode struct {
+	Message  string        // message added by this error, excluding messages of the errors it wraps
+	Branches [][]errorNode // chains of the errors it joins, if any
+}
+
+// newErrorChain turns err into the chain of errors it wraps, outermost first.
+// Errors that add no message of their own are omitted.
+func newErrorChain(err error) []errorNode {
+	message := err.Error()
+
+	var children []error
+	switch wrapped := err.(type) {
+	case interface{ Unwrap() error }:
+		if inner := wrapped.Unwrap(); inner != nil {
+			children = []error{inner}
+		}
+	case interface{ Unwrap() []error }:
+		for _, inner := range wrapped.Unwrap() {
+			if inner != nil {
+				children = append(children, inner)
+			}
+		}
+	}
+
+	switch len(children) {
+	case 0:
+		return []errorNode{{Message: message}}
+	case 1:
+		message = strings.TrimSuffix(message, children[0].Error())
+		message = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(message), ":"))
+
+		chain := newErrorChain(children[0])
+		if message == "" {
+			return chain
+		}
+		return append([]errorNode{{Message: message}}, chain...)
+	default:
+		node := errorNode{Message: "multiple errors"}
+		for _, child := range children {
+			node.Branches = append(node.Branches, newErrorChain(child))
+		}
+		return []errorNode{node}
+	}
+}
+
+var overlayTemplate = template.Must(template.New("overlay").Parse(`
+<div id="dev-error-overlay" role="alert" style="position:fixed;inset:0;z-index:2147483647;overflow:auto;padding:2em;background:rgba(20,20,20,.92);color:#eee;font:14px/1.5 monospace;text-align:left">
+<button type="button" onclick="this.parentNode.remove()" style="float:right;font:inherit;cursor:pointer">close</button>
+<h2 style="color:#ff6b6b;margin-top:0">Build failed</h2>
+<p>The page below is from the last successful build. This overlay disappears once the build succeeds again.</p>
+{{ range .Files }}<p>{{ with .Path }}in <code>{{ . }}</code>{{ end }}{{ if .Source }} from <code>{{ .Source }}{{ if and .Line (not .Template) }}:{{ .Line }}{{ end }}</code>{{ end }}{{ if .Template }} at template <code>{{ .Template }}</code>{{ if .Line }}, line {{ .Line }}{{ end }}{{ end }}</p>
+{{ end }}{{ template "chain" .Chain }}
+</div>
+{{ define "chain" }}<ol style="margin:0;padding-left:1.5em">{{ range . }}<li><pre style="margin:.25em 0;white-space:pre-wrap">{{ .Message }}</pre>{{ range .Branches }}{{ template "chain" . }}{{ end }}</li>{{ end }}</ol>{{ end }}`))
+
+// renderErrorOverlay renders an overlay showing the given build error.
+func renderErrorOverlay(err error) []byte {
+	var buffer bytes.Buffer
+	if e := overlayTemplate.Execute(&buffer, struct {
+		Files []*file.Error
+		Chain []errorNode
+	}{
+		Files: file.Errors(err),
+		Chain: newErrorChain(err),
+	}); e != nil {
+		return []byte("<pre>" + template.HTMLEscapeString(err.Error()) + "</pre>")
+	}
+	return buffer.Bytes()
+}
@@ -0,0 +1,235 @@
+//spellchecker:words generator
+package output
+
+//spellchecker:words bufio bytes context errors slog http httputil strconv strings sync time
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proxy forwards requests to the handler of a [Server] running in another process.
+//
+// It allows restarting that process without browsers noticing.
+// The proxy keeps serving the live reload endpoint itself, relaying events of the current target.
+// When the target changes, all pages reload.
+// When no target is available, requests wait for one to become available.
+//
+// In addition to errors shown by the target itself, the proxy can show an error overlay on every page, see [Proxy.SetFailure].
+type Proxy struct {
+	// Logger is used to log failures to relay live reload events, defaults to discarding them.
+	Logger *slog.Logger
+
+	l       sync.Mutex
+	target  *url.URL
+	proxy   *httputil.ReverseProxy
+	failure error
+	changed chan struct{} // closed and replaced whenever the target or failure changes
+	relay   context.CancelFunc
+
+	live liveReloader
+}
+
+// proxyWait is the maximal time a request waits for a target to become available.
+const proxyWait = 30 * time.Second
+
+// SetTarget makes the proxy forward requests to the server with the given base url, and reloads all pages.
+// A nil target causes requests to wait until a new target is set.
+func (p *Proxy) SetTarget(target *url.URL) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	if p.relay != nil {
+		p.relay()
+		p.relay = nil
+	}
+
+	p.target, p.proxy = target, nil
+	if target != nil {
+		p.proxy = &httputil.ReverseProxy{
+			Rewrite: func(r *httputil.ProxyRequest) {
+				r.SetURL(target)
+				r.Out.Host = r.In.Host
+			},
+			ModifyResponse: p.injectFailure,
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		p.relay = cancel
+		go p.relayEvents(ctx, target)
+
+		p.live.notify(liveReload)
+	}
+	p.notifyChanged()
+}
+
+// SetFailure sets an error to show as an overlay on every page, such as a failure to build the target.
+// Passing nil removes the overlay.
+func (p *Proxy) SetFailure(err error) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	if p.failure == nil && err == nil {
+		return
+	}
+
+	p.failure = err
+	p.live.notify(liveReload)
+	p.notifyChanged()
+}
+
+// notifyChanged wakes up all requests waiting for a change.
+// The caller must hold p.l.
+func (p *Proxy) notifyChanged() {
+	if p.changed != nil {
+		close(p.changed)
+	}
+	p.changed = make(chan struct{})
+}
+
+// state returns the current reverse proxy and failure.
+// If there is neither, it also returns a channel closed once either changes.
+func (p *Proxy) state() (*httputil.ReverseProxy, error, <-chan struct{}) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	if p.changed == nil {
+		p.changed = make(chan struct{})
+	}
+	return p.proxy, p.failure, p.changed
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == liveEventsPath {
+		p.live.ServeHTTP(w, r)
+		return
+	}
+
+	timeout := time.NewTimer(proxyWait)
+	defer timeout.Stop()
+
+	for {
+		proxy, failure, changed := p.state()
+		if proxy != nil {
+			proxy.ServeHTTP(w, r)
+			return
+		}
+
+		if failure != nil {
+			// there is nothing to show the overlay on, so show it on an otherwise empty page
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write(pageContents([]byte("<!DOCTYPE html><title>Build failed</title>"), failure))
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-timeout.C:
+			http.Error(w, "no server available", http.StatusServiceUnavailable)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// injectFailure injects the error overlay into html pages while a failure is set.
+func (p *Proxy) injectFailure(res *http.Response) error {
+	p.l.Lock()
+	failure := p.failure
+	p.l.Unlock()
+
+	if failure == nil || res.Header.Get("Content-Encoding") != "" || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") {
+		return nil
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err := errors.Join(err, res.Body.Close()); err != nil {
+		return err
+	}
+
+	body = injectSnippet(body, renderErrorOverlay(failure))
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// proxyRetry is the time to wait before reconnecting to the live reload endpoint of a target.
+const proxyRetry = time.Second
+
+// relayEvents relays live reload events of the given target to all clients, until ctx is cancelled.
+func (p *Proxy) relayEvents(ctx context.Context, target *url.URL) {
+	logger := p.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+
+	events := target.JoinPath(liveEventsPath).String()
+	for {
+		err := p.relayEventsOnce(ctx, events)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Warn("lost connection to live reload events", slog.String("url", events), slog.Any("error", err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(proxyRetry):
+		}
+	}
+}
+
+// relayEventsOnce connects to the Server-Sent Events endpoint at the given url, and relays events until the connection closes.
+func (p *Proxy) relayEventsOnce(ctx context.Context, events string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, events, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.New(res.Status)
+	}
+
+	var kind string
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, ok := strings.CutPrefix(line, "event:"); ok {
+			kind = strings.TrimSpace(value)
+			continue
+		}
+		if line != "" {
+			continue
+		}
+
+		// the target greets every new connection, only relay actual changes
+		if kind == liveReload || kind == liveCSS {
+			p.live.notify(kind)
+		}
+		kind = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
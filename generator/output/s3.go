@@ -0,0 +1,440 @@
+//spellchecker:words generator
+package output
+
+//spellchecker:words bytes context sha256 json errors slog http path slices strconv strings sync
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.tkw01536.de/blog/generator/file"
+)
+
+// S3Config configures an [S3] output.
+type S3Config struct {
+	// Endpoint is the base url of the S3 API, such as "https://s3.eu-central-1.amazonaws.com" or "http://localhost:9000".
+	// Buckets are addressed using path-style urls.
+	Endpoint string
+
+	Region string // Region used for signing requests, defaults to "us-east-1".
+	Bucket string // Bucket to upload to.
+	Prefix string // Prefix added to the key of every object, such as "mirror/".
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // optional
+
+	// CacheControl returns the Cache-Control header for the file with the given path.
+	// If nil, or if it returns the empty string, no Cache-Control header is set.
+	CacheControl func(path string) string
+
+	// Delete removes remote objects below Prefix that were not written during the generation.
+	// When using a manifest, only objects listed in the manifest are removed.
+	Delete bool
+
+	// ManifestKey is an optional key of an object holding the hashes and headers of all uploaded objects.
+	//
+	// If set, changes are detected by comparing against the manifest instead of remote ETags.
+	// This detects changed headers, and works with providers whose ETags are not md5 hashes.
+	// Objects modified or deleted by other means are not detected.
+	ManifestKey string
+
+	// Concurrency is the maximum number of concurrent requests, defaults to 8.
+	Concurrency int
+
+	// Client is the http client used for requests, defaults to [http.DefaultClient].
+	Client *http.Client
+}
+
+// S3 creates a new [Output] that uploads files to a bucket of an S3-compatible object storage.
+//
+// Files are uploaded once generation has succeeded, only objects that changed are uploaded.
+// Html files are uploaded last, so that pages never reference assets that do not exist yet.
+// When generation fails, nothing is uploaded.
+//
+// Precompressed variants of other files ("name.gz" or "name.zst") are not uploaded.
+// Object storage serves every object as is, and cannot choose a variant based on the encodings a client accepts.
+func S3(config S3Config) Output {
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = 8
+	}
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	return &s3Writer{config: config}
+}
+
+var (
+	errS3Path    = errors.New("path outside of bucket prefix")
+	errS3Request = errors.New("S3 request failed")
+)
+
+type s3Writer struct {
+	config S3Config
+
+	m     sync.Mutex
+	files map[string][]byte // contents of files written since the last reset
+}
+
+// s3Object is the state of a single object.
+type s3Object struct {
+	SHA256       string `json:"sha256,omitempty"`
+	ContentType  string `json:"contentType,omitempty"`
+	CacheControl string `json:"cacheControl,omitempty"`
+
+	etag string // remote ETag, without quotes
+}
+
+func (sw *s3Writer) Reset() error {
+	sw.m.Lock()
+	defer sw.m.Unlock()
+
+	sw.files = make(map[string][]byte)
+	return nil
+}
+
+func (sw *s3Writer) Write(ctx context.Context, logger *slog.Logger, file file.File) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("context closed: %w", err)
+	}
+
+	name := path.Clean(filepath.ToSlash(file.Path))
+	if name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+		return fmt.Errorf("%w: %q", errS3Path, file.Path)
+	}
+
+	sw.m.Lock()
+	defer sw.m.Unlock()
+
+	sw.files[sw.config.Prefix+name] = file.Contents
+	return nil
+}
+
+func (sw *s3Writer) Finish(ctx context.Context, logger *slog.Logger, buildErr error) error {
+	sw.m.Lock()
+	defer sw.m.Unlock()
+
+	files := sw.files
+	sw.files = nil
+
+	if buildErr != nil {
+		logger.Info("not uploading failed generation", slog.String("bucket", sw.config.Bucket))
+		return nil
+	}
+
+	// fetch the remote state
+	var (
+		remote map[string]s3Object
+		err    error
+	)
+	if sw.config.ManifestKey != "" {
+		remote, err = sw.readManifest(ctx)
+	} else {
+		remote, err = sw.list(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get remote objects: %w", err)
+	}
+
+	// determine what changed
+	local := make(map[string]s3Object, len(files))
+	var assets, pages []string
+	var skipped int
+	for _, key := range slices.Sorted(maps.Keys(files)) {
+		if isPrecompressedVariant(key, files) {
+			skipped++
+			continue
+		}
+
+		object := sw.object(key, files[key])
+		local[key] = object
+
+		if existing, ok := remote[key]; ok && sw.unchanged(existing, object) {
+			continue
+		}
+
+		if isHTMLKey(key) {
+			pages = append(pages, key)
+		} else {
+			assets = append(assets, key)
+		}
+	}
+
+	var stale []string
+	if sw.config.Delete {
+		for _, key := range slices.Sorted(maps.Keys(remote)) {
+			if _, ok := local[key]; !ok && key != sw.config.ManifestKey && strings.HasPrefix(key, sw.config.Prefix) {
+				stale = append(stale, key)
+			}
+		}
+	}
+
+	logger.Info("uploading to bucket",
+		slog.String("bucket", sw.config.Bucket),
+		slog.Int("upload", len(assets)+len(pages)),
+		slog.Int("unchanged", len(local)-len(assets)-len(pages)),
+		slog.Int("skipped", skipped),
+		slog.Int("delete", len(stale)),
+	)
+
+	// upload assets before the pages referencing them, and delete last
+	for _, keys := range [][]string{assets, pages} {
+		if err := sw.each(ctx, keys, func(key string) error {
+			logger.Info("uploading object", slog.String("key", key), slog.Int("size", len(files[key])))
+			return sw.put(ctx, key, files[key], local[key])
+		}); err != nil {
+			return err
+		}
+	}
+	if err := sw.each(ctx, stale, func(key string) error {
+		logger.Info("deleting object", slog.String("key", key))
+		return sw.delete(ctx, key)
+	}); err != nil {
+		return err
+	}
+
+	if sw.config.ManifestKey != "" {
+		if err := sw.writeManifest(ctx, local); err != nil {
+			return fmt.Errorf("failed to write manifest: %w", err)
+		}
+	}
+	return nil
+}
+
+// object returns the state of the object holding the given contents.
+func (sw *s3Writer) object(key string, contents []byte) s3Object {
+	var cacheControl string
+	if sw.config.CacheControl != nil {
+		cacheControl = sw.config.CacheControl(strings.TrimPrefix(key, sw.config.Prefix))
+	}
+
+	md5sum := md5.Sum(contents)
+	return s3Object{
+		SHA256:       sha256Hex(contents),
+		ContentType:  file.ContentType(key, contents),
+		CacheControl: cacheControl,
+
+		etag: hex.EncodeToString(md5sum[:]),
+	}
+}
+
+// unchanged checks if the existing remote object is identical to object.
+func (sw *s3Writer) unchanged(existing, object s3Object) bool {
+	if sw.config.ManifestKey == "" {
+		return existing.etag == object.etag
+	}
+	return existing.SHA256 == object.SHA256 &&
+		existing.ContentType == object.ContentType &&
+		existing.CacheControl == object.CacheControl
+}
+
+// isPrecompressedVariant checks if key refers to a precompressed variant of another file in files.
+func isPrecompressedVariant(key string, files map[string][]byte) bool {
+	for _, variant := range precompressedEncodings {
+		if original, ok := strings.CutSuffix(key, variant.extension); ok {
+			if _, ok := files[original]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isHTMLKey checks if key refers to an html page.
+func isHTMLKey(key string) bool {
+	ext := strings.ToLower(path.Ext(key))
+	return ext == ".html" || ext == ".htm"
+}
+
+// each invokes f for every key, with at most the configured number of concurrent invocations.
+func (sw *s3Writer) each(ctx context.Context, keys []string, f func(key string) error) error {
+	var (
+		wg        sync.WaitGroup
+		semaphore = make(chan struct{}, sw.config.Concurrency)
+		errs      = make([]error, len(keys))
+	)
+	for i, key := range keys {
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			break
+		}
+
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			errs[i] = f(key)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// url returns the url of the given key, and the query.
+// An empty key refers to the bucket itself.
+func (sw *s3Writer) url(key string, query url.Values) string {
+	target := strings.TrimSuffix(sw.config.Endpoint, "/") + "/" + s3Escape(sw.config.Bucket, false)
+	if key != "" {
+		target += "/" + s3Escape(key, true)
+	}
+
+	if len(query) > 0 {
+		var parts []string
+		for _, name := range slices.Sorted(maps.Keys(query)) {
+			parts = append(parts, s3Escape(name, false)+"="+s3Escape(query.Get(name), false))
+		}
+		target += "?" + strings.Join(parts, "&")
+	}
+	return target
+}
+
+// do performs a signed request, and returns the response body.
+// If the request fails with a status code in allowed, a nil body and no error are returned.
+func (sw *s3Writer) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte, allowed ...int) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, sw.url(key, query), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = sha256Hex(body)
+	}
+	signV4(req, s3Credentials{
+		AccessKeyID:     sw.config.AccessKeyID,
+		SecretAccessKey: sw.config.SecretAccessKey,
+		SessionToken:    sw.config.SessionToken,
+	}, sw.config.Region, payloadHash, time.Now())
+
+	res, err := sw.config.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s %q: %w", errS3Request, method, key, err)
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s %q: failed to read response: %w", errS3Request, method, key, err)
+	}
+
+	if slices.Contains(allowed, res.StatusCode) {
+		return nil, nil
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		var response struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		_ = xml.Unmarshal(data, &response)
+		return nil, fmt.Errorf("%w: %s %q: %s: %s %s", errS3Request, method, key, res.Status, response.Code, response.Message)
+	}
+	return data, nil
+}
+
+// list lists all remote objects below the configured prefix.
+func (sw *s3Writer) list(ctx context.Context) (map[string]s3Object, error) {
+	objects := make(map[string]s3Object)
+
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {sw.config.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		data, err := sw.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+			Contents              []struct {
+				Key  string `xml:"Key"`
+				ETag string `xml:"ETag"`
+			} `xml:"Contents"`
+		}
+		if err := xml.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("failed to decode object list: %w", err)
+		}
+
+		for _, content := range result.Contents {
+			objects[content.Key] = s3Object{etag: strings.ToLower(strings.Trim(content.ETag, `"`))}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// readManifest reads the remote manifest.
+// A missing manifest is treated as an empty bucket.
+func (sw *s3Writer) readManifest(ctx context.Context) (map[string]s3Object, error) {
+	data, err := sw.do(ctx, http.MethodGet, sw.config.ManifestKey, nil, nil, nil, http.StatusNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make(map[string]s3Object)
+	if data == nil {
+		return objects, nil
+	}
+	if err := json.Unmarshal(data, &objects); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	return objects, nil
+}
+
+// writeManifest writes the remote manifest.
+func (sw *s3Writer) writeManifest(ctx context.Context, objects map[string]s3Object) error {
+	data, err := json.MarshalIndent(objects, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	return sw.put(ctx, sw.config.ManifestKey, data, s3Object{ContentType: "application/json", CacheControl: "no-store"})
+}
+
+// put uploads a single object.
+func (sw *s3Writer) put(ctx context.Context, key string, contents []byte, object s3Object) error {
+	header := http.Header{"Content-Type": {object.ContentType}}
+	if object.CacheControl != "" {
+		header.Set("Cache-Control", object.CacheControl)
+	}
+
+	_, err := sw.do(ctx, http.MethodPut, key, nil, header, contents)
+	return err
+}
+
+// delete deletes a single object.
+func (sw *s3Writer) delete(ctx context.Context, key string) error {
+	_, err := sw.do(ctx, http.MethodDelete, key, nil, nil, nil, http.StatusNotFound)
+	return err
+}
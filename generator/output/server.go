@@ -1,34 +1,92 @@
 //spellchecker:words generator
 package output
 
-//spellchecker:words context slog http sync testing fstest
+//spellchecker:words bytes context errors slog http url path strconv strings sync testing fstest zstd
 import (
+	"bytes"
 	"context"
+	"errors"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
 	"sync"
 	"testing/fstest"
+	"time"
 
 	"go.tkw01536.de/blog/generator/file"
 )
 
 // Server returns a pair of [Output] and [http.Writer].
 // The output is intended to be used as the output of a generation, while the handler serves the generated files.
-func Server() (Output, http.Handler) {
-	dw := &serverWriter{fs: make(fstest.MapFS)}
+//
+// The handler emulates the production host:
+//   - Requests are canonicalized to the link of the file they resolve to, see [file.File.Link].
+//     For example "/post" and "/post/index.html" permanently redirect to "/post/".
+//   - Pages immediately redirecting elsewhere using a meta refresh, such as those produced by the redirect scanner, are served as permanent redirects.
+//   - Missing files are answered using the configured not found page, see [ServerOptions].
+//   - The content type is determined using [file.ContentType], so that feeds are served with their proper type.
+//   - Directory listings are never served.
+//
+// When a precompressed variant of a file ("name.gz" or "name.zst") was generated, it is served to clients accepting the encoding.
+//
+// The handler supports live reloading.
+// A small script is injected into every html page, which listens for Server-Sent Events sent after each successful generation.
+// Pages reload when they change, keeping their scroll position.
+// When only stylesheets change, they are swapped without reloading.
+//
+// Files are served from the last successful generation.
+// A new generation is only served once it has succeeded, requests never observe a partially generated site.
+// When a generation fails, every html page shows an overlay describing the error until the next generation succeeds.
+//
+// The handler also serves a dashboard at "/_dev/", which lists every file being served along with its origin, metadata, size and render time.
+// It also shows the timings and warnings of the last generation, see [Summarizer].
+// The dashboard is not part of any generation, and is never written by other outputs.
+func Server(options ServerOptions) (Output, http.Handler) {
+	dw := &serverWriter{options: options, current: newGeneration()}
 	return dw, dw.Handler()
 }
 
+// ServerOptions configures a [Server].
+type ServerOptions struct {
+	// NotFound is the path of the page served with status 404 for missing files, such as "404.html".
+	// If empty, or the page was not generated, a plain message is served instead.
+	NotFound string
+}
+
 type serverWriter struct {
-	l  sync.Mutex
-	fs fstest.MapFS
+	options ServerOptions
+
+	l         sync.Mutex
+	current   *generation // generation being served, never modified
+	next      *generation // ongoing generation
+	failure   error       // error of the last generation, if it failed
+	lastBuild *Summary    // summary of the last generation, successful or not
+
+	live liveReloader
+}
+
+// generation holds the files of a single generation.
+type generation struct {
+	files     fstest.MapFS
+	redirects map[string]string // targets of pages redirecting elsewhere, by path
+	summary   *Summary          // summary of this generation, if any
+}
+
+func newGeneration() *generation {
+	return &generation{
+		files:     make(fstest.MapFS),
+		redirects: make(map[string]string),
+	}
 }
 
 func (sw *serverWriter) Reset() error {
 	sw.l.Lock()
 	defer sw.l.Unlock()
 
-	clear(sw.fs)
+	sw.next = newGeneration()
 	return nil
 }
 
@@ -36,12 +94,238 @@ func (sw *serverWriter) Write(ctx context.Context, logger *slog.Logger, file fil
 	sw.l.Lock()
 	defer sw.l.Unlock()
 
-	sw.fs[file.Path] = &fstest.MapFile{
+	sw.next.files[file.Path] = &fstest.MapFile{
 		Data: file.Contents,
 	}
 	return nil
 }
 
+func (sw *serverWriter) Summarize(summary *Summary) {
+	sw.l.Lock()
+	defer sw.l.Unlock()
+
+	if sw.next != nil {
+		sw.next.summary = summary
+	}
+	sw.lastBuild = summary
+}
+
+func (sw *serverWriter) Finish(ctx context.Context, logger *slog.Logger, buildErr error) error {
+	sw.l.Lock()
+	next := sw.next
+	sw.next = nil
+
+	if errors.Is(buildErr, context.Canceled) {
+		// a newer generation is on its way, keep showing the state of the last completed one
+		sw.l.Unlock()
+
+		logger.Info("generation cancelled, serving last successful generation")
+		return nil
+	}
+
+	if buildErr != nil || next == nil {
+		sw.failure = buildErr
+		sw.l.Unlock()
+
+		logger.Info("serving last successful generation")
+		if buildErr != nil {
+			// show the error overlay
+			sw.live.notify(liveReload)
+		}
+		return nil
+	}
+
+	next.findRedirects()
+
+	kind := liveChange(sw.current.files, next.files)
+	if sw.failure != nil {
+		// hide the error overlay
+		kind = liveReload
+	}
+	sw.current, sw.failure = next, nil
+	sw.l.Unlock()
+
+	if kind != "" {
+		logger.Info("notifying live reload clients", slog.String("kind", kind))
+		sw.live.notify(kind)
+	}
+	return nil
+}
+
 func (sw *serverWriter) Handler() http.Handler {
-	return http.FileServerFS(sw.fs)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == liveEventsPath {
+			sw.live.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Path == dashboardPath {
+			sw.serveDashboard(w, r)
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// serve the entire request from the same generation
+		current, failure := sw.state()
+
+		name, link, ok := current.resolve(r.URL.Path)
+		switch {
+		case !ok:
+			serveNotFound(current.files, sw.options.NotFound, failure, w, r)
+		case link != r.URL.Path:
+			redirect(w, r, (&url.URL{Path: link, RawQuery: r.URL.RawQuery}).String())
+		case current.redirects[name] != "":
+			redirect(w, r, current.redirects[name])
+		default:
+			serveFile(current.files, name, failure, w, r)
+		}
+	})
+}
+
+// findRedirects records the targets of all pages redirecting elsewhere.
+func (gen *generation) findRedirects() {
+	for name, page := range gen.files {
+		if !isPage(name) {
+			continue
+		}
+		if target, ok := file.RedirectTarget(page.Data); ok {
+			gen.redirects[name] = target
+		}
+	}
+}
+
+// state returns the generation currently being served, and the error of the last generation.
+func (sw *serverWriter) state() (*generation, error) {
+	sw.l.Lock()
+	defer sw.l.Unlock()
+
+	return sw.current, sw.failure
+}
+
+// resolve determines the file served for the given url path.
+// It returns the name of the file, and the canonical path to request it with.
+// If no file exists, returns false.
+func (gen *generation) resolve(urlPath string) (name, link string, ok bool) {
+	name = strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+
+	// regular file, or index page requested by name
+	if _, ok := gen.files[name]; ok && name != "" {
+		return name, file.File{Path: name}.Link(), true
+	}
+
+	// directory with an index page
+	index := path.Join(name, "index.html")
+	if _, ok := gen.files[index]; ok {
+		return index, file.File{Path: index}.Link(), true
+	}
+
+	return "", "", false
+}
+
+// redirect permanently redirects the client to the given url.
+// The redirect must not be cached, as it might change with the next generation.
+func redirect(w http.ResponseWriter, r *http.Request, url string) {
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, url, http.StatusMovedPermanently)
+}
+
+// isPage checks if the file with the given name is an html page.
+func isPage(name string) bool {
+	ext := path.Ext(name)
+	return ext == ".html" || ext == ".htm"
+}
+
+// pageContents returns the contents to serve for an html page.
+// The live reload snippet is injected, and an error overlay if failure is not nil.
+func pageContents(page []byte, failure error) []byte {
+	data := injectSnippet(page, liveSnippet)
+	if failure != nil {
+		data = injectSnippet(data, renderErrorOverlay(failure))
+	}
+	return data
+}
+
+// serveFile serves the file with the given name.
+// If the client accepts it, a precompressed variant is served instead.
+func serveFile(files fstest.MapFS, name string, failure error, w http.ResponseWriter, r *http.Request) {
+	data := files[name].Data
+	w.Header().Set("Content-Type", file.ContentType(name, data))
+
+	if isPage(name) {
+		w.Header().Set("Cache-Control", "no-store")
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(pageContents(data, failure)))
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	accepted := acceptedEncodings(r.Header.Get("Accept-Encoding"))
+	for _, variant := range precompressedEncodings {
+		if !accepted[variant.encoding] {
+			continue
+		}
+		compressed, ok := files[name+variant.extension]
+		if !ok {
+			continue
+		}
+
+		w.Header().Set("Content-Encoding", variant.encoding)
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(compressed.Data))
+		return
+	}
+
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
+
+// serveNotFound answers a request for a missing file using the not found page with the given name, if it exists.
+func serveNotFound(files fstest.MapFS, notFound string, failure error, w http.ResponseWriter, r *http.Request) {
+	page, ok := files[notFound]
+	if notFound == "" || !ok {
+		http.Error(w, "404 page not found", http.StatusNotFound)
+		return
+	}
+
+	data := page.Data
+	if isPage(notFound) {
+		data = pageContents(data, failure)
+	}
+
+	w.Header().Set("Content-Type", file.ContentType(notFound, page.Data))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusNotFound)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(data)
+	}
+}
+
+// precompressedEncodings are the encodings of precompressed variants, in order of preference.
+var precompressedEncodings = []struct {
+	encoding  string
+	extension string
+}{
+	{"zstd", ".zst"},
+	{"gzip", ".gz"},
+}
+
+// acceptedEncodings parses the value of an Accept-Encoding header into the set of accepted encodings.
+func acceptedEncodings(header string) map[string]bool {
+	accepted := make(map[string]bool)
+	for part := range strings.SplitSeq(header, ",") {
+		encoding, params, _ := strings.Cut(part, ";")
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if value, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = value
+			}
+		}
+
+		accepted[encoding] = quality > 0
+	}
+	return accepted
 }
@@ -0,0 +1,56 @@
+//spellchecker:words generator
+package output
+
+//spellchecker:words time
+import (
+	"time"
+
+	"go.tkw01536.de/blog/generator/file"
+)
+
+// Summarizer is an [Output] that is additionally passed a summary of every generation.
+type Summarizer interface {
+	Output
+
+	// Summarize is invoked once per generation, right before Finish.
+	// It is not invoked for generations that were cancelled.
+	Summarize(summary *Summary)
+}
+
+// Summary describes a single generation.
+// It must not be modified.
+type Summary struct {
+	Started time.Time
+	Err     error // error the generation failed with, if any
+
+	Steps []Step        // steps of the generation, in the order they finished
+	Notes []Note        // notes made during the generation, such as warnings
+	Files []FileSummary // files written, sorted by path
+}
+
+// Step is a single step of a generation.
+// Steps run concurrently, so only the time they finished at is recorded.
+type Step struct {
+	Name     string
+	Finished time.Duration // time since the start of the generation
+}
+
+// Note is a note made during a generation.
+type Note struct {
+	Path    string // Path of the file the note refers to.
+	Source  string // Name of the component that made the note.
+	Message string // Human readable message.
+}
+
+// FileSummary describes a single file written during a generation.
+type FileSummary struct {
+	Path   string
+	Origin file.Origin
+	Size   int
+
+	Metadata map[string]any // metadata of the file, if any
+	Indexed  bool           // if the file was passed to index templates
+
+	Rendered   bool          // if the file was rendered using the content template
+	RenderTime time.Duration // time taken by rendering, zero if a cached result was used
+}
@@ -0,0 +1,60 @@
+//spellchecker:words generator
+package output
+
+//spellchecker:words context errors slog
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"go.tkw01536.de/blog/generator/file"
+)
+
+// Tee creates a new [Output] that writes every file to all of the given outputs.
+//
+// Every call is passed to each output in order, even if an earlier output returned an error.
+// Errors of all outputs are combined using [errors.Join].
+func Tee(outputs ...Output) Output {
+	return teeWriter(outputs)
+}
+
+type teeWriter []Output
+
+func (tw teeWriter) Reset() error {
+	return tw.each(func(output Output) error {
+		return output.Reset()
+	})
+}
+
+func (tw teeWriter) Write(ctx context.Context, logger *slog.Logger, file file.File) error {
+	return tw.each(func(output Output) error {
+		return output.Write(ctx, logger, file)
+	})
+}
+
+func (tw teeWriter) Finish(ctx context.Context, logger *slog.Logger, buildErr error) error {
+	return tw.each(func(output Output) error {
+		return output.Finish(ctx, logger, buildErr)
+	})
+}
+
+// each invokes f on every output, and combines the returned errors.
+func (tw teeWriter) each(f func(output Output) error) error {
+	errs := make([]error, len(tw))
+	for i, output := range tw {
+		if err := f(output); err != nil {
+			errs[i] = fmt.Errorf("output %d: %w", i, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Summarize passes the summary to all outputs implementing [Summarizer].
+func (tw teeWriter) Summarize(summary *Summary) {
+	for _, output := range tw {
+		if summarizer, ok := output.(Summarizer); ok {
+			summarizer.Summarize(summary)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+//spellchecker:words generator
+package generator
+
+//spellchecker:words errors path strings
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"go.tkw01536.de/blog/generator/file"
+)
+
+var (
+	errPathEmpty     = errors.New("empty path")
+	errPathAbsolute  = errors.New("absolute path")
+	errPathEscapes   = errors.New("path escapes the output directory")
+	errPathInvalid   = errors.New("path contains a backslash or NUL byte")
+	errPathNotClean  = errors.New("path is not normalized")
+	errCaseCollision = errors.New("output paths only differ in case")
+)
+
+// normalizePath validates and normalizes the path of an output file.
+//
+// Paths must be relative and slash-separated, and must not escape the output directory.
+// Redundant slashes and "." or ".." elements are removed.
+func normalizePath(name string) (string, error) {
+	switch {
+	case name == "":
+		return "", errPathEmpty
+	case strings.ContainsAny(name, "\\\x00"):
+		return "", fmt.Errorf("%w: %q", errPathInvalid, name)
+	case path.IsAbs(name):
+		return "", fmt.Errorf("%w: %q", errPathAbsolute, name)
+	}
+
+	clean := path.Clean(name)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("%w: %q", errPathEscapes, name)
+	}
+	return clean, nil
+}
+
+// validatePaths checks that the paths of the given files are normalized, see [normalizePath].
+// It furthermore checks that no two paths only differ in case, as they would collide on case-insensitive file systems.
+func validatePaths(files []file.File) error {
+	var (
+		errs  []error
+		lower = make(map[string]file.File, len(files))
+	)
+	for _, f := range files {
+		clean, err := normalizePath(f.Path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid path produced by %s: %w", f.Origin, err))
+			continue
+		}
+		if clean != f.Path {
+			errs = append(errs, fmt.Errorf("%w: %q produced by %s", errPathNotClean, f.Path, f.Origin))
+			continue
+		}
+
+		key := strings.ToLower(f.Path)
+		if other, ok := lower[key]; ok && other.Path != f.Path {
+			errs = append(errs, fmt.Errorf("%w: %q by %s and %q by %s", errCaseCollision, other.Path, other.Origin, f.Path, f.Origin))
+			continue
+		}
+		lower[key] = f
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,239 @@
+//spellchecker:words generator
+package generator
+
+//spellchecker:words context sha256 errors slog filepath sync atomic time fsnotify
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// WatchMethod determines how [Generator.Watch] detects changes.
+type WatchMethod int
+
+const (
+	// WatchAuto uses the native file system notifications, and falls back to polling when they are not available.
+	// Notifications are considered unavailable when they cannot be set up, or when polling finds a change they missed.
+	// This is the default.
+	WatchAuto WatchMethod = iota
+
+	// WatchNative only uses the native file system notifications.
+	WatchNative
+
+	// WatchPoll periodically walks all watched paths, and compares modification times, sizes and hashes of files.
+	// It works on file systems without notifications, such as bind mounts into containers or network file systems.
+	WatchPoll
+)
+
+// defaultPollInterval is used when [Generator.PollInterval] is not set.
+const defaultPollInterval = time.Second
+
+// polledFile is the state of a file as last seen by a [poller].
+type polledFile struct {
+	size    int64
+	modTime time.Time
+	hash    [sha256.Size]byte
+}
+
+// poller detects changes by walking a set of paths.
+type poller struct {
+	paths []string              // directories and files to walk
+	files map[string]polledFile // files seen by the last walk
+}
+
+// poll walks all paths and returns events describing the changes since the previous call.
+// The first call only records the current state.
+func (p *poller) poll(logger *slog.Logger) []fsnotify.Event {
+	initial := p.files == nil
+
+	var events []fsnotify.Event
+	seen := make(map[string]polledFile, len(p.files))
+	for _, root := range p.paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// paths may be removed or not yet exist
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+
+			info, err := d.Info()
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			current := polledFile{size: info.Size(), modTime: info.ModTime()}
+			previous, existed := p.files[path]
+
+			// only read the file when it might have changed
+			if existed && current.size == previous.size && current.modTime.Equal(previous.modTime) {
+				seen[path] = previous
+				return nil
+			}
+
+			contents, err := os.ReadFile(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			current.hash = sha256.Sum256(contents)
+			seen[path] = current
+
+			switch {
+			case initial:
+			case !existed:
+				events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Create})
+			case current.hash != previous.hash:
+				events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Write})
+			}
+			return nil
+		})
+		if err != nil {
+			logger.Error("failed to poll for changes", slog.String("path", root), slog.Any("error", err))
+		}
+	}
+
+	for path := range p.files {
+		if _, ok := seen[path]; !ok {
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Remove})
+		}
+	}
+
+	p.files = seen
+	return events
+}
+
+// pollNotifier is like [Generator.nativeNotifier], but detects changes by polling.
+//
+// If filter is not nil, it is called with the events found by every poll, and only the events it returns are sent.
+func (generator *Generator) pollNotifier(ctx context.Context, logger *slog.Logger, filter func(events []fsnotify.Event) []fsnotify.Event) (<-chan fsnotify.Event, func() error, error) {
+	p := &poller{paths: generator.watchPaths()}
+	p.poll(logger)
+
+	interval := generator.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	logger.Info("polling for changes", slog.Duration("interval", interval), slog.Any("paths", p.paths))
+
+	ctx, cancel := context.WithCancel(ctx)
+	c := make(chan fsnotify.Event, 1)
+	go func() {
+		defer close(c)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			events := p.poll(logger)
+			if filter != nil {
+				events = filter(events)
+			}
+
+			for _, e := range events {
+				logger.Info("poller triggered", slog.String("event", e.String()))
+				select {
+				case c <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return c, func() error { cancel(); return nil }, nil
+}
+
+// autoNotifier uses native notifications, and falls back to polling when they do not work.
+// See [WatchAuto].
+func (generator *Generator) autoNotifier(ctx context.Context, logger *slog.Logger) (<-chan fsnotify.Event, func() error, error) {
+	native, closeNative, err := generator.nativeNotifier(ctx, logger)
+	if err != nil {
+		logger.Warn("native file system notifications unavailable, polling instead", slog.Any("error", err))
+		return generator.pollNotifier(ctx, logger, nil)
+	}
+
+	// recent is set whenever a notification was received since the last poll.
+	// A poll may find a change just before its notification arrives.
+	// Notifications are thus only considered broken once no notification arrived until the next poll either.
+	var (
+		recent  atomic.Bool
+		broken  bool             // only accessed by the poller
+		pending []fsnotify.Event // changes found by the previous poll without a notification
+	)
+	polled, closePolled, err := generator.pollNotifier(ctx, logger, func(events []fsnotify.Event) []fsnotify.Event {
+		notified := recent.Swap(false)
+		switch {
+		case broken:
+			return events
+		case len(pending) > 0 && !notified:
+			logger.Warn("native file system notifications missed changes, polling instead")
+			broken = true
+			return append(pending, events...)
+		case notified:
+			pending = nil
+		default:
+			pending = events
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errors.Join(err, closeNative())
+	}
+
+	c := make(chan fsnotify.Event, 1)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for e := range native {
+			recent.Store(true)
+			select {
+			case c <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for e := range polled {
+			select {
+			case c <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(c)
+	}()
+
+	return c, func() error { return errors.Join(closeNative(), closePolled()) }, nil
+}
@@ -11,6 +11,7 @@ import (
 	"regexp"
 	"strings"
 
+	"go.tkw01536.de/blog/generator/cache"
 	"go.tkw01536.de/blog/generator/file"
 
 	"github.com/tdewolff/minify/v2"
@@ -22,28 +23,101 @@ import (
 	"github.com/tdewolff/minify/v2/xml"
 )
 
-// PostProcessor post processes a file before outputting
-type PostProcessor func(in file.File) (out file.File, err error)
+// PostProcessor post processes a file before outputting.
+// It may be called concurrently for different files.
+type PostProcessor func(ctx context.Context, logger *slog.Logger, in file.File) (out file.File, err error)
+
+// postProcessed is the cached result of post processing a file.
+type postProcessed struct {
+	File  file.File
+	Notes []ReportNote // notes added to the report while post processing
+}
 
 func (generator *Generator) postProcess(
 	ctx context.Context,
 	logger *slog.Logger,
 	f file.File,
 ) (file.File, error) {
-	logger.Info("post processing file", slog.String("path", f.Path))
-	for _, processor := range generator.PostProcessors {
+	key := cache.NewKey(
+		"post",
+		fmt.Appendf(nil, "%d", generator.postVersion),
+		[]byte(f.Path),
+		f.Contents,
+	)
+	origin := f.Origin
+
+	result, err := cache.Do(cache.FromContext(ctx), key, func() (postProcessed, error) {
+		logger.Info("post processing file", slog.String("path", f.Path))
+
+		var result postProcessed
+		notes, err := recordNotes(ctx, func(ctx context.Context) error {
+			for _, processor := range generator.PostProcessors {
+				var err error
+				f, err = processor(ctx, logger, f)
+				if err != nil {
+					return file.NewError(file.File{Path: f.Path, Origin: origin}, fmt.Errorf("failed to post process: %w", err))
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+
+		result.File, result.Notes = f, notes
+		return result, nil
+	})
+	if err != nil {
+		return file.File{}, err
+	}
+
+	ReportFromContext(ctx).addNotes(result.Notes)
+
+	// the cached result may have been processed from a different origin
+	out := result.File
+	out.Origin = origin
+	return out, nil
+}
+
+// updatePostVersion updates the version identifying the PostProcessors, to be used in cache keys.
+// The version changes whenever PostProcessors was replaced since the previous run.
+//
+// Post processors are compared by their slice.
+// The previous slice is kept, so that its backing array cannot be reused by a different slice.
+func (generator *Generator) updatePostVersion() {
+	previous, current := generator.postProcessors, generator.PostProcessors
+	if generator.postVersion == 0 || len(previous) != len(current) || len(current) > 0 && &previous[0] != &current[0] {
+		generator.postVersion++
+	}
+	generator.postProcessors = current
+}
+
+// Finalizer processes the set of all output files once all of them have been post-processed.
+// It may add, remove, rename or modify files, and returns the new set of files.
+type Finalizer func(ctx context.Context, logger *slog.Logger, files []file.File) ([]file.File, error)
+
+func (generator *Generator) finalize(
+	ctx context.Context,
+	logger *slog.Logger,
+	files []file.File,
+) ([]file.File, error) {
+	logger.Info("finalizing files", slog.Int("count", len(files)))
+	for _, finalizer := range generator.Finalizers {
 		var err error
-		f, err = processor(f)
+		files, err = finalizer(ctx, logger, files)
 		if err != nil {
-			return file.File{}, fmt.Errorf("failed to post process: %w", err)
+			return nil, fmt.Errorf("failed to finalize: %w", err)
 		}
 	}
 
-	return f, nil
+	return files, nil
 }
 
 var m *minify.M
 
+// minifyModule is the module providing minification.
+const minifyModule = "github.com/tdewolff/minify/v2"
+
 func init() {
 	m = minify.New()
 	m.AddFunc("text/css", css.Minify)
@@ -54,7 +128,9 @@ func init() {
 	m.AddFuncRegexp(regexp.MustCompile("[/+]xml$"), xml.Minify)
 }
 
-func MinifyPostProcessor(in file.File) (out file.File, err error) {
+// MinifyPostProcessor minifies css, html, svg, javascript, json and xml files.
+// Minified files are persisted, see [cache.Persist].
+func MinifyPostProcessor(ctx context.Context, logger *slog.Logger, in file.File) (out file.File, err error) {
 	ext := strings.ToLower(filepath.Ext(in.Path))
 
 	var mediaType string
@@ -75,13 +151,22 @@ func MinifyPostProcessor(in file.File) (out file.File, err error) {
 		return in, nil
 	}
 
-	var buffer bytes.Buffer
-	if err := m.Minify(mediaType, &buffer, bytes.NewReader(in.Contents)); err != nil {
-		return file.File{}, fmt.Errorf("failed to minify %q: %w", in.Path, err)
+	key := cache.NewKey("minify", []byte(cache.ModuleVersion(minifyModule)), []byte(mediaType), in.Contents)
+	contents, err := cache.Persist(cache.FromContext(ctx), logger, key, func() ([]byte, error) {
+		var buffer bytes.Buffer
+		if err := m.Minify(mediaType, &buffer, bytes.NewReader(in.Contents)); err != nil {
+			return nil, fmt.Errorf("failed to minify %q: %w", in.Path, err)
+		}
+		return buffer.Bytes(), nil
+	})
+	if err != nil {
+		return file.File{}, err
 	}
+
 	return file.File{
 		Path:     in.Path,
-		Contents: buffer.Bytes(),
+		Contents: contents,
+		Origin:   in.Origin,
 	}, nil
 }
 
@@ -0,0 +1,101 @@
+//spellchecker:words generator
+package generator
+
+//spellchecker:words context slog slices strings sync
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Report collects notes about a single run of the generator.
+//
+// A report is created for every run, and can be retrieved from the context passed to components using [ReportFromContext].
+// Once the run has finished, the notes in the report are logged.
+type Report struct {
+	m     sync.Mutex
+	notes []ReportNote
+}
+
+// ReportNote is a single note inside a [Report].
+type ReportNote struct {
+	Path    string // Path of the file the note refers to.
+	Source  string // Name of the component that made the note.
+	Message string // Human readable message.
+}
+
+// Add adds a new note to this report.
+// Add may be called concurrently, and is a no-op on a nil report.
+func (report *Report) Add(path, source, message string) {
+	if report == nil {
+		return
+	}
+
+	report.m.Lock()
+	defer report.m.Unlock()
+
+	report.notes = append(report.notes, ReportNote{Path: path, Source: source, Message: message})
+}
+
+// addNotes adds all the given notes to this report.
+func (report *Report) addNotes(notes []ReportNote) {
+	if report == nil {
+		return
+	}
+
+	report.m.Lock()
+	defer report.m.Unlock()
+
+	report.notes = append(report.notes, notes...)
+}
+
+// Notes returns the notes in this report, sorted by path and source.
+func (report *Report) Notes() []ReportNote {
+	if report == nil {
+		return nil
+	}
+
+	report.m.Lock()
+	defer report.m.Unlock()
+
+	notes := slices.Clone(report.notes)
+	slices.SortStableFunc(notes, func(left, right ReportNote) int {
+		return cmp.Or(
+			strings.Compare(left.Path, right.Path),
+			strings.Compare(left.Source, right.Source),
+		)
+	})
+	return notes
+}
+
+// log logs all notes in this report to the given logger.
+func (report *Report) log(logger *slog.Logger) {
+	for _, note := range report.Notes() {
+		logger.Info("build report", slog.String("path", note.Path), slog.String("source", note.Source), slog.String("message", note.Message))
+	}
+}
+
+type reportContextKey struct{}
+
+// withReport returns a new context that holds the given report.
+func withReport(ctx context.Context, report *Report) context.Context {
+	return context.WithValue(ctx, reportContextKey{}, report)
+}
+
+// recordNotes invokes f with a context holding a new report, and returns the notes added to it.
+// This allows results of a step to be cached together with the notes it made.
+func recordNotes(ctx context.Context, f func(ctx context.Context) error) ([]ReportNote, error) {
+	report := new(Report)
+	err := f(withReport(ctx, report))
+	return report.notes, err
+}
+
+// ReportFromContext returns the report of the run the context belongs to.
+// If there is no such report, returns nil.
+func ReportFromContext(ctx context.Context) *Report {
+	report, _ := ctx.Value(reportContextKey{}).(*Report)
+	return report
+}
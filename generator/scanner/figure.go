@@ -0,0 +1,136 @@
+//spellchecker:words generator
+package scanner
+
+//spellchecker:words strconv github yuin goldmark parser renderer html util figcaption
+import (
+	"strconv"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Figures is a goldmark extension to be passed to [Markdown].
+//
+// It turns every paragraph that consists of only a single image with a title into a figure.
+// The title of the image becomes the caption of the figure.
+// Figures are numbered in order of appearance within a single post, and can be linked to using "#figure-<number>".
+//
+// For example:
+//
+//	![A cat](/media/cat.jpg "A cat sitting on a keyboard")
+//
+// is rendered as
+//
+//	<figure id="figure-1"><img src="/media/cat.jpg" alt="A cat"><figcaption><a href="#figure-1">Figure 1</a>: A cat sitting on a keyboard</figcaption></figure>
+var Figures goldmark.Extender = figures{}
+
+// KindFigure is the [ast.NodeKind] of a [Figure].
+var KindFigure = ast.NewNodeKind("Figure")
+
+// Figure is a block node representing a numbered figure.
+// It has exactly one child, the [ast.Image] being shown.
+type Figure struct {
+	ast.BaseBlock
+
+	Number  int    // Number of this figure within the document, starting at 1.
+	Caption []byte // Caption of this figure, taken from the title of the image.
+}
+
+// ID returns the html id of this figure.
+func (figure *Figure) ID() string {
+	return "figure-" + strconv.Itoa(figure.Number)
+}
+
+func (figure *Figure) Kind() ast.NodeKind {
+	return KindFigure
+}
+
+func (figure *Figure) Dump(source []byte, level int) {
+	ast.DumpHelper(figure, source, level, map[string]string{
+		"Number":  strconv.Itoa(figure.Number),
+		"Caption": string(figure.Caption),
+	}, nil)
+}
+
+type figures struct{}
+
+func (figures) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithASTTransformers(
+			util.Prioritized(figureTransformer{}, 999),
+		),
+	)
+	m.Renderer().AddOptions(
+		renderer.WithNodeRenderers(
+			util.Prioritized(figureRenderer{}, 500),
+		),
+	)
+}
+
+// figureTransformer replaces paragraphs holding only a titled image with a [Figure].
+type figureTransformer struct{}
+
+func (figureTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	// find all the paragraphs to replace first, so that we don't modify the tree while walking it
+	var paragraphs []*ast.Paragraph
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		paragraph, ok := n.(*ast.Paragraph)
+		if !ok {
+			return ast.WalkContinue, nil
+		}
+
+		if paragraph.ChildCount() != 1 {
+			return ast.WalkSkipChildren, nil
+		}
+		if image, ok := paragraph.FirstChild().(*ast.Image); !ok || len(image.Title) == 0 {
+			return ast.WalkSkipChildren, nil
+		}
+
+		paragraphs = append(paragraphs, paragraph)
+		return ast.WalkSkipChildren, nil
+	})
+
+	for i, paragraph := range paragraphs {
+		image := paragraph.FirstChild().(*ast.Image)
+
+		figure := &Figure{
+			Number:  i + 1,
+			Caption: image.Title,
+		}
+		image.Title = nil // the title is shown in the caption instead
+
+		figure.AppendChild(figure, image)
+		paragraph.Parent().ReplaceChild(paragraph.Parent(), paragraph, figure)
+	}
+}
+
+// figureRenderer renders a [Figure] as html.
+type figureRenderer struct{}
+
+func (figureRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindFigure, renderFigure)
+}
+
+func renderFigure(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	figure := node.(*Figure)
+	id := figure.ID()
+
+	if entering {
+		_, _ = w.WriteString(`<figure id="` + id + `">`)
+		return ast.WalkContinue, nil
+	}
+
+	_, _ = w.WriteString(`<figcaption><a href="#` + id + `">Figure ` + strconv.Itoa(figure.Number) + `</a>: `)
+	html.DefaultWriter.Write(w, figure.Caption)
+	_, _ = w.WriteString("</figcaption></figure>\n")
+	return ast.WalkContinue, nil
+}
@@ -1,20 +1,23 @@
 //spellchecker:words generator
 package scanner
 
-//spellchecker:words bytes path filepath strings github yuin goldmark meta parser golang html
+//spellchecker:words bytes context slog strings github yuin goldmark meta parser golang html
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/fs"
-	"path/filepath"
+	"log/slog"
 	"strings"
 
+	"go.tkw01536.de/blog/generator/cache"
 	"go.tkw01536.de/blog/generator/file"
 
 	"github.com/yuin/goldmark"
 	meta "github.com/yuin/goldmark-meta"
 	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
 	"golang.org/x/net/html"
 )
 
@@ -22,7 +25,10 @@ import (
 // files are added to the index if the index function returns true, or shouldIndex is nil.
 //
 // Internally uses [os.Root], and ensures that no files outside the given directory are caught.
+// Converted files are cached, and rendered html is persisted, see [cache.Persist].
+// Because options cannot be compared, persisted results are only reused by an identical executable.
 func Markdown(path string, shouldIndex func(path string, Metadata map[string]any) bool, options ...goldmark.Option) Scanner {
+	root := path
 	markdown := goldmark.New(
 		append([]goldmark.Option{
 			goldmark.WithExtensions(
@@ -32,42 +38,41 @@ func Markdown(path string, shouldIndex func(path string, Metadata map[string]any
 	)
 	return &fsScanner{
 		open: openRootFS(path),
-		process: func(path string, d fs.DirEntry, contents []byte) (file.ScannedFile, error) {
+		process: func(ctx context.Context, logger *slog.Logger, path string, d fs.DirEntry, contents []byte) (file.ScannedFile, error) {
 			// check if the file is excluded
 			name := d.Name()
 			if !strings.HasSuffix(name, ".md") {
 				return file.ScannedFile{}, ErrExcluded
 			}
 
-			context := parser.NewContext()
-
-			// parse markdown
-			var markdownResult bytes.Buffer
-			if err := markdown.Convert(contents, &markdownResult, parser.WithContext(context)); err != nil {
-				return file.ScannedFile{}, fmt.Errorf("failed to convert markdown: %w", err)
-			}
-
-			// addRel to external links
-			var contentBuffer bytes.Buffer
-			if err := addTargetAndRel(&contentBuffer, &markdownResult); err != nil {
-				return file.ScannedFile{}, fmt.Errorf("failed to make links open in new tab: %w", err)
+			var (
+				version = []byte(cache.ExecutableVersion())
+				key     = cache.NewKey("markdown", version, []byte(root), contents)
+				htmlKey = cache.NewKey("markdown-html", version, []byte(root), contents)
+			)
+			result, err := cache.Do(cache.FromContext(ctx), key, func() (markdownResult, error) {
+				return convertMarkdown(ctx, logger, markdown, htmlKey, contents)
+			})
+			if err != nil {
+				return file.ScannedFile{}, err
 			}
 
 			// check if we should index!
-			metadata := meta.Get(context)
+			metadata := result.Metadata
 			doIndex := true
 			if shouldIndex != nil {
 				doIndex = shouldIndex(path, metadata)
 			}
 
+			// paths from fs.WalkDir are slash-separated on every platform, so avoid filepath here.
 			// by default, make the destination file '[slug]/index.html'
-			filename := filepath.Join(path[:len(path)-len(".md")], "index.html")
+			filename := path[:len(path)-len(".md")] + "/index.html"
 
 			// if we have _[something].md directly output that as [something].html
 			if name := d.Name(); strings.HasPrefix(name, "_") {
 				nameWithHTML := name[:len(name)-len(".md")] + ".html"
 				nameWithHTML = nameWithHTML[1:]
-				filename = filepath.Join(filepath.Dir(path), nameWithHTML)
+				filename = path[:len(path)-len(name)] + nameWithHTML
 			}
 
 			// and then use
@@ -75,7 +80,7 @@ func Markdown(path string, shouldIndex func(path string, Metadata map[string]any
 				FileWithMetadata: file.FileWithMetadata{
 					File: file.File{
 						Path:     filename,
-						Contents: contentBuffer.Bytes(),
+						Contents: result.Contents,
 					},
 					Metadata: metadata,
 				},
@@ -84,7 +89,52 @@ func Markdown(path string, shouldIndex func(path string, Metadata map[string]any
 			}, nil
 		},
 		paths: []string{path},
+
+		name: "markdown",
+		root: root,
+	}
+}
+
+// markdownResult is the result of converting a markdown file.
+type markdownResult struct {
+	Contents []byte
+	Metadata map[string]any
+}
+
+// convertMarkdown converts the given markdown source into html, and extracts its metadata.
+// The rendered html is persisted under key.
+//
+// Parsing is cheap, and always needed to extract metadata.
+// Rendering includes syntax highlighting and is expensive, so it is skipped when a persisted result exists.
+func convertMarkdown(ctx context.Context, logger *slog.Logger, markdown goldmark.Markdown, key cache.Key, contents []byte) (markdownResult, error) {
+	parserContext := parser.NewContext()
+
+	// parse markdown
+	document := markdown.Parser().Parse(text.NewReader(contents), parser.WithContext(parserContext))
+	metadata := meta.Get(parserContext)
+
+	html, err := cache.Persist(cache.FromContext(ctx), logger, key, func() ([]byte, error) {
+		var rendered bytes.Buffer
+		if err := markdown.Renderer().Render(&rendered, contents, document); err != nil {
+			return nil, fmt.Errorf("failed to convert markdown: %w", err)
+		}
+
+		// addRel to external links
+		var contentBuffer bytes.Buffer
+		if err := addTargetAndRel(&contentBuffer, &rendered); err != nil {
+			return nil, fmt.Errorf("failed to make links open in new tab: %w", err)
+		}
+
+		return contentBuffer.Bytes(), nil
+	})
+	if err != nil {
+		return markdownResult{}, err
 	}
+
+	return markdownResult{
+		Contents: html,
+		Metadata: metadata,
+	}, nil
 }
 
 // addTargetAndRel adds target="_blank" rel="noopener noreferrer" to all links in the given HTML, unless they start with '#'
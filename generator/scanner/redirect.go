@@ -33,7 +33,7 @@ func (scanner redirectScanner) Scan(ctx context.Context, logger *slog.Logger, fi
 
 		file := file.ScannedFile{
 			FileWithMetadata: file.FileWithMetadata{
-				File: file.File{Path: path, Contents: buffer.Bytes()},
+				File: file.File{Path: path, Contents: buffer.Bytes(), Origin: file.Origin{Scanner: "redirect"}},
 			},
 			Raw: true,
 		}
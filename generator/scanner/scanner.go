@@ -3,7 +3,7 @@
 //spellchecker:words generator
 package scanner
 
-//spellchecker:words context errors slog
+//spellchecker:words context errors slog filepath
 import (
 	"context"
 	"errors"
@@ -11,6 +11,7 @@ import (
 	"io/fs"
 	"log/slog"
 	"os"
+	"path/filepath"
 
 	"go.tkw01536.de/blog/generator/file"
 )
@@ -29,6 +30,17 @@ type Scanner interface {
 	Paths() []string
 }
 
+// PartialScanner is a [Scanner] that can rescan only some of its sources.
+// It is used to rebuild only what changed in Watch mode.
+type PartialScanner interface {
+	Scanner
+
+	// ScanPaths is like Scan, but only scans the sources at the given file system paths.
+	// Each path is inside one of the paths returned by Paths, and may refer to a file or a directory.
+	// Paths that no longer exist are skipped.
+	ScanPaths(ctx context.Context, logger *slog.Logger, paths []string, files chan<- file.ScannedFile) error
+}
+
 // ErrExcluded is used by [newFSScanner] to indicate that a file is to be skipped.
 var ErrExcluded = errors.New("file excluded")
 
@@ -37,8 +49,11 @@ type fsScanner struct {
 	// open opens the given filesystem.
 	open func() (fs.FS, error)
 	// process processes a single file from the filesystem into a file.
-	process func(path string, d fs.DirEntry, contents []byte) (file.ScannedFile, error)
+	process func(ctx context.Context, logger *slog.Logger, path string, d fs.DirEntry, contents []byte) (file.ScannedFile, error)
 	paths   []string
+
+	name string // name of the scanner, recorded in the [file.Origin] of produced files
+	root string // directory the filesystem was opened from
 }
 
 func (scanner *fsScanner) Scan(ctx context.Context, logger *slog.Logger, files chan<- file.ScannedFile) error {
@@ -47,7 +62,37 @@ func (scanner *fsScanner) Scan(ctx context.Context, logger *slog.Logger, files c
 		return fmt.Errorf("failed to open filesystem: %w", err)
 	}
 
-	if err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+	return scanner.walk(ctx, logger, fsys, ".", files)
+}
+
+func (scanner *fsScanner) ScanPaths(ctx context.Context, logger *slog.Logger, paths []string, files chan<- file.ScannedFile) error {
+	fsys, err := scanner.open()
+	if err != nil {
+		return fmt.Errorf("failed to open filesystem: %w", err)
+	}
+
+	for _, path := range paths {
+		rel, err := filepath.Rel(scanner.root, path)
+		if err != nil || !filepath.IsLocal(rel) {
+			return fmt.Errorf("path %q is not inside %q", path, scanner.root)
+		}
+		rel = filepath.ToSlash(rel)
+
+		if _, err := fs.Stat(fsys, rel); errors.Is(err, fs.ErrNotExist) {
+			logger.Info("skipping removed file", slog.String("path", rel))
+			continue
+		}
+
+		if err := scanner.walk(ctx, logger, fsys, rel, files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walk scans all files inside the given directory, or the file with the given path.
+func (scanner *fsScanner) walk(ctx context.Context, logger *slog.Logger, fsys fs.FS, root string, files chan<- file.ScannedFile) error {
+	if err := fs.WalkDir(fsys, root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -64,18 +109,25 @@ func (scanner *fsScanner) Scan(ctx context.Context, logger *slog.Logger, files c
 			return fmt.Errorf("failed to read file %q: %w", path, err)
 		}
 
-		file, err := scanner.process(path, d, contents)
+		scanned, err := scanner.process(ctx, logger, path, d, contents)
 		if errors.Is(err, ErrExcluded) {
 			logger.Info("skipping file %q", slog.String("path", path))
 			return nil
 		}
 
+		origin := scanner.origin(path)
 		if err != nil {
-			return fmt.Errorf("failed to process file %q: %w", path, err)
+			return file.NewError(file.File{Path: path, Origin: origin}, fmt.Errorf("failed to process file %q: %w", path, err))
 		}
 
+		scanned.Origin = origin
+
 		logger.Info("scanned file", slog.String("path", path))
-		files <- file
+		select {
+		case files <- scanned:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 		return nil
 	}); err != nil {
 		return fmt.Errorf("WalkDir failed: %w", err)
@@ -83,6 +135,14 @@ func (scanner *fsScanner) Scan(ctx context.Context, logger *slog.Logger, files c
 	return nil
 }
 
+// origin returns the origin of files produced from the file at path.
+func (scanner *fsScanner) origin(path string) file.Origin {
+	return file.Origin{
+		Scanner: scanner.name,
+		Source:  filepath.ToSlash(filepath.Join(scanner.root, path)),
+	}
+}
+
 func (scanner *fsScanner) Paths() []string {
 	return scanner.paths
 }
@@ -1,9 +1,11 @@
 //spellchecker:words generator
 package scanner
 
-//spellchecker:words strings
+//spellchecker:words context slog
 import (
+	"context"
 	"io/fs"
+	"log/slog"
 
 	"go.tkw01536.de/blog/generator/file"
 )
@@ -17,7 +19,7 @@ import (
 func Static(path string, exclude func(name string) bool) Scanner {
 	return &fsScanner{
 		open: openRootFS(path),
-		process: func(path string, d fs.DirEntry, contents []byte) (file.ScannedFile, error) {
+		process: func(ctx context.Context, logger *slog.Logger, path string, d fs.DirEntry, contents []byte) (file.ScannedFile, error) {
 			// check if the file is to be excluded
 			if exclude != nil && exclude(d.Name()) {
 				return file.ScannedFile{}, ErrExcluded
@@ -37,5 +39,8 @@ func Static(path string, exclude func(name string) bool) Scanner {
 			}, nil
 		},
 		paths: []string{path},
+
+		name: "static",
+		root: path,
 	}
 }
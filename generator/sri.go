@@ -0,0 +1,257 @@
+//spellchecker:words generator
+package generator
+
+//spellchecker:words bytes context sha256 sha512 base64 errors slog strings golang html crossorigin modulepreload
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"go.tkw01536.de/blog/generator/file"
+
+	"golang.org/x/net/html"
+)
+
+// SubresourceIntegrity adds "integrity" and "crossorigin" attributes to stylesheets and scripts referenced from html files.
+//
+// Hashes of local resources are computed from the final contents of the referenced file.
+// External resources only receive an integrity attribute when they are pinned.
+// Tags that already have an integrity attribute are left unchanged.
+//
+// Use [SubresourceIntegrity.Finalize] as a [Finalizer].
+// When used together with [Fingerprint], it should come afterwards.
+type SubresourceIntegrity struct {
+	// Pinned holds integrity metadata of external resources, indexed by their url.
+	Pinned map[string]PinnedResource
+}
+
+// PinnedResource is the pinned integrity metadata of an external resource.
+type PinnedResource struct {
+	// Integrity is the value of the integrity attribute, for example "sha384-<base64 hash>".
+	Integrity string
+
+	// Vendored is an optional file system path to a local copy of the resource.
+	// If set, finalizing fails unless every hash in Integrity matches the local copy.
+	Vendored string
+}
+
+// hashes returns the individual hashes in the integrity metadata.
+func (pinned PinnedResource) hashes() []string {
+	return strings.Fields(pinned.Integrity)
+}
+
+var (
+	errIntegrityMismatch    = errors.New("pinned integrity does not match vendored copy")
+	errIntegrityEmpty       = errors.New("pinned integrity is empty")
+	errIntegrityUnsupported = errors.New("unsupported integrity hash algorithm")
+)
+
+// Finalize adds integrity attributes to all html files.
+// It implements [Finalizer].
+func (sri *SubresourceIntegrity) Finalize(ctx context.Context, logger *slog.Logger, files []file.File) ([]file.File, error) {
+	if err := sri.verifyPinned(); err != nil {
+		return nil, err
+	}
+
+	contents := make(map[string][]byte, len(files))
+	for _, f := range files {
+		contents[f.Path] = f.Contents
+	}
+
+	var unpinned []string
+
+	// integrity determines the integrity attribute for the given reference.
+	integrity := func(name, ref string) (value string, ok bool) {
+		if pinned, ok := sri.Pinned[ref]; ok {
+			// never emit an empty attribute, it would not check anything
+			hashes := pinned.hashes()
+			return strings.Join(hashes, " "), len(hashes) > 0
+		}
+
+		target, local := resolveReference(name, ref)
+		if !local {
+			if !slices.Contains(unpinned, ref) {
+				unpinned = append(unpinned, ref)
+			}
+			return "", false
+		}
+
+		data, ok := contents[target]
+		if !ok {
+			logger.Warn("no integrity for missing file", slog.String("path", name), slog.String("ref", ref))
+			return "", false
+		}
+
+		sum := sha512.Sum384(data)
+		return "sha384-" + base64.StdEncoding.EncodeToString(sum[:]), true
+	}
+
+	for i, f := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !isHTML(f.Path) {
+			continue
+		}
+
+		var out bytes.Buffer
+		if err := addIntegrity(&out, bytes.NewReader(f.Contents), func(ref string) (string, bool) {
+			return integrity(f.Path, ref)
+		}); err != nil {
+			return nil, fmt.Errorf("failed to add integrity to %q: %w", f.Path, err)
+		}
+		files[i] = file.File{Path: f.Path, Contents: out.Bytes(), Origin: f.Origin}
+	}
+
+	for _, ref := range unpinned {
+		logger.Info("external resource has no pinned integrity", slog.String("ref", ref))
+	}
+
+	return files, nil
+}
+
+// verifyPinned checks that all pinned resources have integrity metadata, and that it matches vendored copies.
+func (sri *SubresourceIntegrity) verifyPinned() error {
+	for ref, pinned := range sri.Pinned {
+		hashes := pinned.hashes()
+		if len(hashes) == 0 {
+			return fmt.Errorf("%w: %q", errIntegrityEmpty, ref)
+		}
+		if pinned.Vendored == "" {
+			continue
+		}
+
+		data, err := os.ReadFile(pinned.Vendored)
+		if err != nil {
+			return fmt.Errorf("failed to read vendored copy of %q: %w", ref, err)
+		}
+
+		for _, value := range hashes {
+			ok, err := checkIntegrity(value, data)
+			if err != nil {
+				return fmt.Errorf("failed to check integrity of %q: %w", ref, err)
+			}
+			if !ok {
+				return fmt.Errorf("%w: %q (vendored at %q)", errIntegrityMismatch, ref, pinned.Vendored)
+			}
+		}
+	}
+	return nil
+}
+
+// checkIntegrity checks if a single hash of an integrity attribute matches data.
+func checkIntegrity(value string, data []byte) (bool, error) {
+	algorithm, digest, _ := strings.Cut(value, "-")
+
+	// strip options, see https://www.w3.org/TR/SRI/#the-integrity-attribute
+	digest, _, _ = strings.Cut(digest, "?")
+
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha384":
+		h = sha512.New384()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return false, fmt.Errorf("%w: %q", errIntegrityUnsupported, algorithm)
+	}
+
+	h.Write(data)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)) == digest, nil
+}
+
+// addIntegrity adds integrity and crossorigin attributes to stylesheets and scripts in the given html.
+// integrity is called with the referenced url, and returns the attribute value to use, if any.
+func addIntegrity(dst io.Writer, src io.Reader, integrity func(ref string) (string, bool)) error {
+	tokenizer := html.NewTokenizer(src)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			err := tokenizer.Err()
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to parse html: %w", err)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			// Token lower-cases names within the buffer returned by Raw, so copy it first
+			raw := bytes.Clone(tokenizer.Raw())
+			token := tokenizer.Token()
+
+			if ref, ok := subresourceReference(&token); ok {
+				if value, ok := integrity(ref); ok {
+					token.Attr = append(token.Attr, html.Attribute{Key: "integrity", Val: value})
+					if !hasAttribute(&token, "crossorigin") {
+						token.Attr = append(token.Attr, html.Attribute{Key: "crossorigin", Val: "anonymous"})
+					}
+
+					if _, err := io.WriteString(dst, token.String()); err != nil {
+						return fmt.Errorf("failed to write out modified token: %w", err)
+					}
+					continue
+				}
+			}
+
+			if _, err := dst.Write(raw); err != nil {
+				return fmt.Errorf("failed to write out token: %w", err)
+			}
+		default:
+			if _, err := dst.Write(tokenizer.Raw()); err != nil {
+				return fmt.Errorf("failed to write out token: %w", err)
+			}
+		}
+	}
+}
+
+// subresourceReference returns the url of the resource referenced by the given token, if it supports integrity checking.
+func subresourceReference(token *html.Token) (string, bool) {
+	if hasAttribute(token, "integrity") {
+		return "", false
+	}
+
+	attr := "src"
+	switch token.Data {
+	case "script":
+	case "link":
+		rel := strings.Fields(strings.ToLower(attribute(token, "rel")))
+		as := strings.ToLower(attribute(token, "as"))
+		if !slices.Contains(rel, "stylesheet") && !slices.Contains(rel, "modulepreload") &&
+			!(slices.Contains(rel, "preload") && (as == "style" || as == "script")) {
+			return "", false
+		}
+		attr = "href"
+	default:
+		return "", false
+	}
+
+	ref := attribute(token, attr)
+	return ref, ref != ""
+}
+
+// attribute returns the value of the given attribute of token, or the empty string.
+func attribute(token *html.Token, key string) string {
+	for _, attr := range token.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// hasAttribute checks if token has the given attribute.
+func hasAttribute(token *html.Token, key string) bool {
+	return slices.ContainsFunc(token.Attr, func(attr html.Attribute) bool {
+		return attr.Key == key
+	})
+}
@@ -0,0 +1,105 @@
+//spellchecker:words generator
+package generator
+
+//spellchecker:words context sha512 base64 errors slog path filepath testing crossorigin
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.tkw01536.de/blog/generator/file"
+)
+
+// testIntegrity returns the sha384 integrity metadata of the given contents.
+func testIntegrity(contents string) string {
+	sum := sha512.Sum384([]byte(contents))
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestSubresourceIntegrity_Finalize(t *testing.T) {
+	t.Parallel()
+
+	const (
+		script = `console.log("hi")`
+		remote = "https://example.com/remote.js"
+	)
+
+	vendored := filepath.Join(t.TempDir(), "remote.js")
+	if err := os.WriteFile(vendored, []byte("remote"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		pinned  map[string]PinnedResource
+		page    string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "local",
+			page: `<script src="/app.js"></script>`,
+			want: `<script src="/app.js" integrity="` + testIntegrity(script) + `" crossorigin="anonymous"></script>`,
+		},
+		{
+			name: "unpinned",
+			page: `<SCRIPT src="` + remote + `"></SCRIPT>`,
+			want: `<SCRIPT src="` + remote + `"></SCRIPT>`,
+		},
+		{
+			name:   "pinned",
+			pinned: map[string]PinnedResource{remote: {Integrity: "  sha256-abc  sha384-def "}},
+			page:   `<script src="` + remote + `"></script>`,
+			want:   `<script src="` + remote + `" integrity="sha256-abc sha384-def" crossorigin="anonymous"></script>`,
+		},
+		{
+			name:   "vendored",
+			pinned: map[string]PinnedResource{remote: {Integrity: testIntegrity("remote"), Vendored: vendored}},
+			page:   `<script src="` + remote + `"></script>`,
+			want:   `<script src="` + remote + `" integrity="` + testIntegrity("remote") + `" crossorigin="anonymous"></script>`,
+		},
+		{
+			name:    "vendored mismatch",
+			pinned:  map[string]PinnedResource{remote: {Integrity: testIntegrity("changed"), Vendored: vendored}},
+			wantErr: errIntegrityMismatch,
+		},
+		{
+			name:    "empty",
+			pinned:  map[string]PinnedResource{remote: {}},
+			wantErr: errIntegrityEmpty,
+		},
+		{
+			name:    "only spaces",
+			pinned:  map[string]PinnedResource{remote: {Integrity: " \t", Vendored: vendored}},
+			wantErr: errIntegrityEmpty,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			sri := SubresourceIntegrity{Pinned: tt.pinned}
+			files := []file.File{
+				{Path: "index.html", Contents: []byte(tt.page)},
+				{Path: "app.js", Contents: []byte(script)},
+			}
+
+			got, err := sri.Finalize(context.Background(), slog.New(slog.DiscardHandler), files)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Finalize() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if page := string(got[0].Contents); page != tt.want {
+				t.Errorf("Finalize() = %q, want %q", page, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,355 @@
+//spellchecker:words generator
+package generator
+
+//spellchecker:words bytes context binary errors crc32 slog path filepath slices strings exif iptc jfif adobe photoshop iccp itxt ztxt
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go.tkw01536.de/blog/generator/file"
+)
+
+// MetadataAllowlist determines which image metadata is kept by [StripImageMetadata].
+type MetadataAllowlist struct {
+	// Orientation keeps the EXIF orientation of an image.
+	// All other EXIF tags are removed.
+	Orientation bool
+
+	// ColorProfile keeps embedded ICC color profiles.
+	ColorProfile bool
+}
+
+// StripImageMetadata returns a [PostProcessor] that removes privacy-sensitive metadata from JPEG and PNG images.
+//
+// From JPEGs it removes EXIF, XMP and IPTC segments as well as comments.
+// From PNGs it removes textual chunks, EXIF chunks and the last modification time.
+// Pixel data is never re-encoded.
+//
+// Metadata in allow is kept.
+// Every file that was changed is added to the [Report] of the run.
+func StripImageMetadata(allow MetadataAllowlist) PostProcessor {
+	return func(ctx context.Context, logger *slog.Logger, in file.File) (file.File, error) {
+		var strip func([]byte, MetadataAllowlist) ([]byte, []string, error)
+		switch strings.ToLower(filepath.Ext(in.Path)) {
+		case ".jpg", ".jpeg":
+			strip = stripJPEG
+		case ".png":
+			strip = stripPNG
+		default:
+			return in, nil
+		}
+
+		contents, removed, err := strip(in.Contents, allow)
+		if err != nil {
+			return file.File{}, fmt.Errorf("failed to strip metadata from %q: %w", in.Path, err)
+		}
+		if len(removed) == 0 {
+			return in, nil
+		}
+
+		ReportFromContext(ctx).Add(in.Path, "StripImageMetadata", "removed "+strings.Join(removed, ", "))
+
+		return file.File{
+			Path:     in.Path,
+			Contents: contents,
+			Origin:   in.Origin,
+		}, nil
+	}
+}
+
+var (
+	errJPEGInvalid = errors.New("invalid jpeg")
+	errPNGInvalid  = errors.New("invalid png")
+)
+
+// JPEG markers used by [stripJPEG].
+const (
+	jpegSOI  = 0xD8 // start of image
+	jpegSOS  = 0xDA // start of scan
+	jpegAPP0 = 0xE0
+	jpegAPP1 = 0xE1
+	jpegAPP2 = 0xE2
+	jpegAPPD = 0xED
+	jpegAPPE = 0xEE
+	jpegAPPF = 0xEF
+	jpegCOM  = 0xFE // comment
+)
+
+// stripJPEG removes metadata segments from the given jpeg.
+// It returns the new contents, and a human-readable list of what was removed.
+func stripJPEG(contents []byte, allow MetadataAllowlist) ([]byte, []string, error) {
+	if len(contents) < 2 || contents[0] != 0xFF || contents[1] != jpegSOI {
+		return nil, nil, errJPEGInvalid
+	}
+
+	var (
+		out     bytes.Buffer
+		removed []string
+	)
+	out.Write(contents[:2])
+
+	rest := contents[2:]
+	for {
+		// skip fill bytes
+		for len(rest) >= 2 && rest[0] == 0xFF && rest[1] == 0xFF {
+			rest = rest[1:]
+		}
+		if len(rest) < 4 || rest[0] != 0xFF {
+			return nil, nil, errJPEGInvalid
+		}
+
+		marker := rest[1]
+		length := int(binary.BigEndian.Uint16(rest[2:4]))
+		if length < 2 || len(rest) < 2+length {
+			return nil, nil, errJPEGInvalid
+		}
+
+		// the scan is followed by entropy-coded data, which is copied as-is.
+		if marker == jpegSOS {
+			out.Write(rest)
+			break
+		}
+
+		segment, payload := rest[:2+length], rest[4:2+length]
+		rest = rest[2+length:]
+
+		kind := jpegSegmentKind(marker, payload)
+		switch kind {
+		case "":
+			out.Write(segment)
+		case "ICC profile":
+			if allow.ColorProfile {
+				out.Write(segment)
+				continue
+			}
+			removed = appendOnce(removed, kind)
+		case "EXIF":
+			tiff := payload[len(exifHeader):]
+			if allow.Orientation && onlyOrientation(tiff) {
+				out.Write(segment)
+				continue
+			}
+
+			removed = appendOnce(removed, kind)
+			if !allow.Orientation {
+				continue
+			}
+			if orientation, ok := exifOrientation(tiff); ok {
+				exif := append([]byte(exifHeader), minimalExif(orientation)...)
+				out.Write([]byte{0xFF, jpegAPP1})
+				out.Write(binary.BigEndian.AppendUint16(nil, uint16(2+len(exif))))
+				out.Write(exif)
+			}
+		default:
+			removed = appendOnce(removed, kind)
+		}
+	}
+
+	if len(removed) == 0 {
+		return contents, nil, nil
+	}
+	return out.Bytes(), removed, nil
+}
+
+const (
+	exifHeader = "Exif\x00\x00"
+	xmpHeader  = "http://ns.adobe.com/xap/1.0/\x00"
+	xmpExt     = "http://ns.adobe.com/xmp/extension/\x00"
+	iccHeader  = "ICC_PROFILE\x00"
+	iptcHeader = "Photoshop 3.0\x00"
+)
+
+// jpegSegmentKind returns a human readable description of metadata contained in the segment with the given marker and payload.
+// If the segment is required for decoding, or otherwise kept, returns the empty string.
+func jpegSegmentKind(marker byte, payload []byte) string {
+	switch {
+	case marker == jpegCOM:
+		return "comment"
+	case marker == jpegAPP1 && bytes.HasPrefix(payload, []byte(exifHeader)):
+		return "EXIF"
+	case marker == jpegAPP1 && (bytes.HasPrefix(payload, []byte(xmpHeader)) || bytes.HasPrefix(payload, []byte(xmpExt))):
+		return "XMP"
+	case marker == jpegAPP2 && bytes.HasPrefix(payload, []byte(iccHeader)):
+		return "ICC profile"
+	case marker == jpegAPPD && bytes.HasPrefix(payload, []byte(iptcHeader)):
+		return "IPTC"
+	case marker == jpegAPP0, marker == jpegAPPE:
+		// JFIF and Adobe segments are needed to decode the image correctly
+		return ""
+	case marker > jpegAPP0 && marker <= jpegAPPF:
+		// other application segments hold vendor-specific metadata
+		return fmt.Sprintf("APP%d", marker-jpegAPP0)
+	default:
+		return ""
+	}
+}
+
+// exifOrientationTag is the tag of the orientation in the first EXIF IFD.
+const exifOrientationTag = 0x0112
+
+// exifIFD reads the header of the given tiff-encoded exif data.
+// It returns the byte order, the entries of the first IFD, and whatever follows them.
+func exifIFD(tiff []byte) (order binary.ByteOrder, entries [][]byte, rest []byte, ok bool) {
+	if len(tiff) < 8 {
+		return nil, nil, nil, false
+	}
+
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, nil, nil, false
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return nil, nil, nil, false
+	}
+
+	count := int(order.Uint16(tiff[offset:]))
+	rest = tiff[offset+2:]
+	for range count {
+		if len(rest) < 12 {
+			return nil, nil, nil, false
+		}
+		entries = append(entries, rest[:12])
+		rest = rest[12:]
+	}
+	return order, entries, rest, true
+}
+
+// exifOrientation reads the orientation from the given tiff-encoded exif data.
+func exifOrientation(tiff []byte) (orientation uint16, ok bool) {
+	order, entries, _, ok := exifIFD(tiff)
+	if !ok {
+		return 0, false
+	}
+
+	for _, entry := range entries {
+		if order.Uint16(entry[0:2]) == exifOrientationTag && order.Uint16(entry[2:4]) == 3 {
+			return order.Uint16(entry[8:10]), true
+		}
+	}
+	return 0, false
+}
+
+// onlyOrientation checks if the given tiff-encoded exif data holds nothing but the orientation.
+// Such data is kept as is, rather than being replaced by [minimalExif].
+func onlyOrientation(tiff []byte) bool {
+	order, entries, rest, ok := exifIFD(tiff)
+	if !ok || len(entries) != 1 || order.Uint16(entries[0][0:2]) != exifOrientationTag || order.Uint16(entries[0][2:4]) != 3 {
+		return false
+	}
+
+	// the IFD ends with the offset of the next IFD, which must not exist, and nothing may follow it
+	return len(rest) == 4 && order.Uint32(rest) == 0
+}
+
+// minimalExif returns tiff-encoded exif data holding only the given orientation.
+func minimalExif(orientation uint16) []byte {
+	var exif []byte
+	exif = append(exif, 'M', 'M', 0, 42)          // big endian tiff header
+	exif = binary.BigEndian.AppendUint32(exif, 8) // offset of first IFD
+	exif = binary.BigEndian.AppendUint16(exif, 1) // number of entries
+	exif = binary.BigEndian.AppendUint16(exif, exifOrientationTag)
+	exif = binary.BigEndian.AppendUint16(exif, 3) // type SHORT
+	exif = binary.BigEndian.AppendUint32(exif, 1) // count
+	exif = binary.BigEndian.AppendUint16(exif, orientation)
+	exif = append(exif, 0, 0)                     // padding of value
+	exif = binary.BigEndian.AppendUint32(exif, 0) // no next IFD
+	return exif
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// stripPNG removes metadata chunks from the given png.
+// It returns the new contents, and a human-readable list of what was removed.
+func stripPNG(contents []byte, allow MetadataAllowlist) ([]byte, []string, error) {
+	if !bytes.HasPrefix(contents, pngSignature) {
+		return nil, nil, errPNGInvalid
+	}
+
+	var (
+		out     bytes.Buffer
+		removed []string
+	)
+	out.Write(pngSignature)
+
+	rest := contents[len(pngSignature):]
+	for len(rest) > 0 {
+		if len(rest) < 12 {
+			return nil, nil, errPNGInvalid
+		}
+		length := int(binary.BigEndian.Uint32(rest[:4]))
+		if length > len(rest)-12 {
+			return nil, nil, errPNGInvalid
+		}
+
+		chunk, typ, data := rest[:12+length], string(rest[4:8]), rest[8:8+length]
+		rest = rest[12+length:]
+
+		switch typ {
+		case "tEXt", "zTXt", "iTXt":
+			removed = appendOnce(removed, "text")
+		case "tIME":
+			removed = appendOnce(removed, "modification time")
+		case "iCCP":
+			if allow.ColorProfile {
+				out.Write(chunk)
+				continue
+			}
+			removed = appendOnce(removed, "ICC profile")
+		case "eXIf":
+			if allow.Orientation && onlyOrientation(data) {
+				out.Write(chunk)
+				continue
+			}
+
+			removed = appendOnce(removed, "EXIF")
+			if !allow.Orientation {
+				continue
+			}
+			if orientation, ok := exifOrientation(data); ok {
+				writePNGChunk(&out, "eXIf", minimalExif(orientation))
+			}
+		default:
+			out.Write(chunk)
+		}
+	}
+
+	if len(removed) == 0 {
+		return contents, nil, nil
+	}
+	return out.Bytes(), removed, nil
+}
+
+// writePNGChunk writes a png chunk with the given type and data to out.
+func writePNGChunk(out *bytes.Buffer, typ string, data []byte) {
+	out.Write(binary.BigEndian.AppendUint32(nil, uint32(len(data))))
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+
+	out.WriteString(typ)
+	out.Write(data)
+	out.Write(crc.Sum(nil))
+}
+
+// appendOnce appends value to values unless it is already contained.
+func appendOnce(values []string, value string) []string {
+	if slices.Contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
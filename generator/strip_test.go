@@ -0,0 +1,301 @@
+//spellchecker:words generator
+package generator
+
+//spellchecker:words bytes context binary image jpeg slices testing exif iccp itxt
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"slices"
+	"testing"
+
+	"go.tkw01536.de/blog/generator/file"
+)
+
+// testImage returns a small image to embed metadata into.
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for x := range 8 {
+		for y := range 8 {
+			img.Set(x, y, color.RGBA{uint8(32 * x), uint8(32 * y), 128, 255})
+		}
+	}
+	return img
+}
+
+// testJPEG returns a jpeg with the given segments inserted right after the start of image marker.
+func testJPEG(t *testing.T, segments ...[]byte) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	base := buffer.Bytes()
+	return slices.Concat(base[:2], slices.Concat(segments...), base[2:])
+}
+
+// jpegSegment returns a jpeg segment with the given marker and payload.
+func jpegSegment(marker byte, payload ...[]byte) []byte {
+	data := slices.Concat(payload...)
+	return slices.Concat([]byte{0xFF, marker}, binary.BigEndian.AppendUint16(nil, uint16(2+len(data))), data)
+}
+
+// testPNG returns a png with the given chunks inserted right after the header chunk.
+func testPNG(t *testing.T, chunks ...[]byte) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	base := buffer.Bytes()
+
+	header := len(pngSignature) + 12 + 13 // signature and IHDR chunk
+	return slices.Concat(base[:header], slices.Concat(chunks...), base[header:])
+}
+
+// pngChunk returns a png chunk with the given type and data.
+func pngChunk(typ string, data string) []byte {
+	var buffer bytes.Buffer
+	writePNGChunk(&buffer, typ, []byte(data))
+	return buffer.Bytes()
+}
+
+// testExif returns little endian tiff-encoded exif data holding an orientation and, unless onlyOrientation is set, a camera model.
+func testExif(orientation uint16, onlyOrientation bool) []byte {
+	order := binary.LittleEndian
+
+	count := uint16(2)
+	if onlyOrientation {
+		count = 1
+	}
+
+	exif := []byte{'I', 'I', 42, 0}
+	exif = order.AppendUint32(exif, 8)
+	exif = order.AppendUint16(exif, count)
+	if !onlyOrientation {
+		exif = order.AppendUint16(exif, 0x0110) // model
+		exif = order.AppendUint16(exif, 2)      // type ASCII
+		exif = order.AppendUint32(exif, 4)
+		exif = append(exif, "Cam\x00"...)
+	}
+	exif = order.AppendUint16(exif, exifOrientationTag)
+	exif = order.AppendUint16(exif, 3) // type SHORT
+	exif = order.AppendUint32(exif, 1)
+	exif = order.AppendUint16(exif, orientation)
+	exif = append(exif, 0, 0)
+	exif = order.AppendUint32(exif, 0) // no next IFD
+	return exif
+}
+
+var (
+	testICC = []byte("not really a color profile")
+	testXMP = []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`)
+)
+
+func TestStripJPEG(t *testing.T) {
+	t.Parallel()
+
+	exif := jpegSegment(jpegAPP1, []byte(exifHeader), testExif(6, false))
+	orientation := jpegSegment(jpegAPP1, []byte(exifHeader), testExif(6, true))
+	minimal := jpegSegment(jpegAPP1, []byte(exifHeader), minimalExif(6))
+	icc := jpegSegment(jpegAPP2, []byte(iccHeader), []byte{1, 1}, testICC)
+	xmp := jpegSegment(jpegAPP1, []byte(xmpHeader), testXMP)
+	comment := jpegSegment(jpegCOM, []byte("taken at home"))
+	iptc := jpegSegment(jpegAPPD, []byte(iptcHeader), []byte("8BIM"))
+
+	keepAll := MetadataAllowlist{Orientation: true, ColorProfile: true}
+
+	tests := []struct {
+		name        string
+		in          []byte
+		allow       MetadataAllowlist
+		want        []byte
+		wantRemoved []string
+	}{
+		{"no metadata", testJPEG(t), MetadataAllowlist{}, testJPEG(t), nil},
+		{"exif removed", testJPEG(t, exif), MetadataAllowlist{}, testJPEG(t), []string{"EXIF"}},
+		{"exif reduced to orientation", testJPEG(t, exif), keepAll, testJPEG(t, minimal), []string{"EXIF"}},
+		{"orientation kept as is", testJPEG(t, orientation), keepAll, testJPEG(t, orientation), nil},
+		{"orientation removed", testJPEG(t, orientation), MetadataAllowlist{}, testJPEG(t), []string{"EXIF"}},
+		{"icc kept", testJPEG(t, icc), keepAll, testJPEG(t, icc), nil},
+		{"icc removed", testJPEG(t, icc), MetadataAllowlist{}, testJPEG(t), []string{"ICC profile"}},
+		{"everything", testJPEG(t, exif, icc, xmp, comment, iptc, comment), keepAll, testJPEG(t, minimal, icc), []string{"EXIF", "XMP", "comment", "IPTC"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, removed, err := stripJPEG(tt.in, tt.allow)
+			if err != nil {
+				t.Fatalf("stripJPEG() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("stripJPEG() = %x, want %x", got, tt.want)
+			}
+			if !slices.Equal(removed, tt.wantRemoved) {
+				t.Errorf("stripJPEG() removed = %v, want %v", removed, tt.wantRemoved)
+			}
+			if _, err := jpeg.Decode(bytes.NewReader(got)); err != nil {
+				t.Errorf("stripJPEG() produced undecodable image: %v", err)
+			}
+		})
+	}
+}
+
+func TestStripJPEG_malformed(t *testing.T) {
+	t.Parallel()
+
+	valid := testJPEG(t, jpegSegment(jpegCOM, []byte("comment")))
+
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"empty", nil},
+		{"not a jpeg", []byte("GIF89a")},
+		{"only start of image", valid[:2]},
+		{"truncated segment", valid[:10]},
+		{"invalid segment length", slices.Concat(valid[:2], []byte{0xFF, jpegCOM, 0, 1}, valid[2:])},
+		{"garbage between segments", slices.Concat(valid[:2], []byte{0x00}, valid[2:])},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, _, err := stripJPEG(tt.in, MetadataAllowlist{}); err == nil {
+				t.Error("stripJPEG() expected error, got none")
+			}
+		})
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	t.Parallel()
+
+	exif := pngChunk("eXIf", string(testExif(3, false)))
+	orientation := pngChunk("eXIf", string(testExif(3, true)))
+	minimal := pngChunk("eXIf", string(minimalExif(3)))
+	icc := pngChunk("iCCP", "profile\x00\x00"+string(testICC))
+	text := pngChunk("tEXt", "Author\x00someone")
+	itxt := pngChunk("iTXt", "Comment\x00\x00\x00\x00\x00hello")
+	modified := pngChunk("tIME", "\x07\xe9\x01\x02\x03\x04\x05")
+
+	keepAll := MetadataAllowlist{Orientation: true, ColorProfile: true}
+
+	tests := []struct {
+		name        string
+		in          []byte
+		allow       MetadataAllowlist
+		want        []byte
+		wantRemoved []string
+	}{
+		{"no metadata", testPNG(t), MetadataAllowlist{}, testPNG(t), nil},
+		{"text removed", testPNG(t, text, itxt), keepAll, testPNG(t), []string{"text"}},
+		{"modification time removed", testPNG(t, modified), keepAll, testPNG(t), []string{"modification time"}},
+		{"exif reduced to orientation", testPNG(t, exif), keepAll, testPNG(t, minimal), []string{"EXIF"}},
+		{"orientation kept as is", testPNG(t, orientation), keepAll, testPNG(t, orientation), nil},
+		{"exif removed", testPNG(t, exif), MetadataAllowlist{}, testPNG(t), []string{"EXIF"}},
+		{"icc kept", testPNG(t, icc), keepAll, testPNG(t, icc), nil},
+		{"icc removed", testPNG(t, icc), MetadataAllowlist{}, testPNG(t), []string{"ICC profile"}},
+		{"everything", testPNG(t, icc, text, exif, modified, itxt), keepAll, testPNG(t, icc, minimal), []string{"text", "EXIF", "modification time"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, removed, err := stripPNG(tt.in, tt.allow)
+			if err != nil {
+				t.Fatalf("stripPNG() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("stripPNG() = %x, want %x", got, tt.want)
+			}
+			if !slices.Equal(removed, tt.wantRemoved) {
+				t.Errorf("stripPNG() removed = %v, want %v", removed, tt.wantRemoved)
+			}
+			if _, err := png.Decode(bytes.NewReader(got)); err != nil {
+				t.Errorf("stripPNG() produced undecodable image: %v", err)
+			}
+		})
+	}
+}
+
+func TestStripPNG_malformed(t *testing.T) {
+	t.Parallel()
+
+	valid := testPNG(t)
+
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"empty", nil},
+		{"not a png", []byte("GIF89a")},
+		{"truncated chunk header", valid[:len(pngSignature)+6]},
+		{"truncated chunk", valid[:len(valid)-4]},
+		{"chunk length too large", slices.Concat(pngSignature, []byte{0x7F, 0xFF, 0xFF, 0xFF}, valid[len(pngSignature)+4:])},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, _, err := stripPNG(tt.in, MetadataAllowlist{}); err == nil {
+				t.Error("stripPNG() expected error, got none")
+			}
+		})
+	}
+}
+
+func TestStripImageMetadata(t *testing.T) {
+	t.Parallel()
+
+	strip := StripImageMetadata(MetadataAllowlist{Orientation: true})
+
+	tests := []struct {
+		name      string
+		in        file.File
+		wantNotes []ReportNote
+	}{
+		{
+			name:      "stripped jpeg",
+			in:        file.File{Path: "photo.JPG", Contents: testJPEG(t, jpegSegment(jpegCOM, []byte("hi")))},
+			wantNotes: []ReportNote{{Path: "photo.JPG", Source: "StripImageMetadata", Message: "removed comment"}},
+		},
+		{
+			name: "unchanged png",
+			in:   file.File{Path: "diagram.png", Contents: testPNG(t)},
+		},
+		{
+			name: "other file",
+			in:   file.File{Path: "index.html", Contents: []byte("<p>not an image</p>")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			report := new(Report)
+			got, err := strip(withReport(context.Background(), report), nil, tt.in)
+			if err != nil {
+				t.Fatalf("StripImageMetadata() error = %v", err)
+			}
+			if got.Path != tt.in.Path {
+				t.Errorf("StripImageMetadata() path = %q, want %q", got.Path, tt.in.Path)
+			}
+			if notes := report.Notes(); !slices.Equal(notes, tt.wantNotes) {
+				t.Errorf("StripImageMetadata() notes = %v, want %v", notes, tt.wantNotes)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+//spellchecker:words generator
+package generator
+
+//spellchecker:words context slices strings sync time
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.tkw01536.de/blog/generator/file"
+	"go.tkw01536.de/blog/generator/output"
+)
+
+// runSummary collects information about a single run, to be passed to outputs implementing [output.Summarizer].
+// Methods may be called concurrently, and are no-ops on a nil runSummary.
+type runSummary struct {
+	m       sync.Mutex
+	started time.Time
+	steps   []output.Step
+	scanned map[string]file.ScannedFile // scanned files, by normalized path
+	renders map[string]time.Duration    // time taken to render files, by path
+}
+
+func newRunSummary() *runSummary {
+	return &runSummary{
+		started: time.Now(),
+		scanned: make(map[string]file.ScannedFile),
+		renders: make(map[string]time.Duration),
+	}
+}
+
+// step records that the step with the given name just finished.
+func (rs *runSummary) step(name string) {
+	if rs == nil {
+		return
+	}
+
+	rs.m.Lock()
+	defer rs.m.Unlock()
+
+	rs.steps = append(rs.steps, output.Step{Name: name, Finished: time.Since(rs.started)})
+}
+
+// scan records a scanned file.
+func (rs *runSummary) scan(f file.ScannedFile) {
+	if rs == nil {
+		return
+	}
+
+	rs.m.Lock()
+	defer rs.m.Unlock()
+
+	rs.scanned[f.Path] = f
+}
+
+// render records the time taken to render the file with the given path.
+func (rs *runSummary) render(path string, took time.Duration) {
+	if rs == nil {
+		return
+	}
+
+	rs.m.Lock()
+	defer rs.m.Unlock()
+
+	rs.renders[path] = took
+}
+
+// summary creates a summary of a run that wrote the given files, and finished with the given error.
+func (rs *runSummary) summary(files []file.File, report *Report, err error) *output.Summary {
+	rs.m.Lock()
+	defer rs.m.Unlock()
+
+	summary := &output.Summary{
+		Started: rs.started,
+		Err:     err,
+		Steps:   slices.Clone(rs.steps),
+		Files:   make([]output.FileSummary, len(files)),
+	}
+
+	for _, note := range report.Notes() {
+		summary.Notes = append(summary.Notes, output.Note(note))
+	}
+
+	for i, f := range files {
+		scanned := rs.scanned[f.Path]
+		took, rendered := rs.renders[f.Path]
+
+		summary.Files[i] = output.FileSummary{
+			Path:   f.Path,
+			Origin: f.Origin,
+			Size:   len(f.Contents),
+
+			Metadata: scanned.Metadata,
+			Indexed:  scanned.Indexed,
+
+			Rendered:   rendered,
+			RenderTime: took,
+		}
+	}
+	slices.SortFunc(summary.Files, func(left, right output.FileSummary) int {
+		return strings.Compare(left.Path, right.Path)
+	})
+
+	return summary
+}
+
+type summaryContextKey struct{}
+
+// withSummary returns a new context that holds the given summary.
+func withSummary(ctx context.Context, rs *runSummary) context.Context {
+	return context.WithValue(ctx, summaryContextKey{}, rs)
+}
+
+// summaryFromContext returns the summary of the run the context belongs to, or nil.
+func summaryFromContext(ctx context.Context) *runSummary {
+	rs, _ := ctx.Value(summaryContextKey{}).(*runSummary)
+	return rs
+}
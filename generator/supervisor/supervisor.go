@@ -0,0 +1,392 @@
+// Package supervisor restarts the generator whenever its own code changes.
+//
+//spellchecker:words generator
+package supervisor
+
+//spellchecker:words context errors slog http exec path filepath regexp runtime strconv strings time github farmergreg rfsnotify
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/farmergreg/rfsnotify"
+	"go.tkw01536.de/blog/generator/file"
+	"go.tkw01536.de/blog/generator/output"
+)
+
+// Supervisor builds and runs the generator in watch mode, and restarts it whenever its code changes.
+//
+// The supervisor serves the dev server of the generator through an [output.Proxy].
+// The address it listens on, and the live reload connections of browsers, stay the same across restarts.
+// When the generator fails to compile, the previous one keeps running, and the compiler errors are shown as an overlay.
+type Supervisor struct {
+	Addr    string   // Address to serve on, such as "localhost:8080".
+	Dir     string   // Directory of the module containing the generator, defaults to the working directory.
+	Package string   // Main package of the generator, relative to Dir, defaults to ".".
+	Args    []string // Arguments passed to the generator.
+
+	// Env holds additional environment variables passed to the generator, such as "WATCH=1".
+	// The address the generator should serve on is passed as "ADDR".
+	Env []string
+}
+
+// supervisorDebounce is the time to wait for further changes before rebuilding.
+const supervisorDebounce = 100 * time.Millisecond
+
+// Run runs the supervisor until ctx is cancelled.
+func (sv *Supervisor) Run(ctx context.Context, logger *slog.Logger) error {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+
+	proxy := &output.Proxy{Logger: logger}
+
+	listener, err := net.Listen("tcp", sv.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	server := &http.Server{Handler: proxy}
+	go func() {
+		logger.Info("supervisor listening", slog.String("addr", sv.Addr))
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("supervisor failed to serve", slog.Any("error", err))
+		}
+	}()
+	defer server.Close()
+
+	temp, err := os.MkdirTemp("", "supervisor-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(temp)
+
+	watcher, err := rfsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to watch directories: %w", err)
+	}
+	defer watcher.Close()
+
+	var (
+		current *child
+		builds  int
+	)
+	defer func() {
+		proxy.SetTarget(nil)
+		current.stop(logger)
+	}()
+
+	// restart rebuilds the generator, and replaces the running one if that succeeds.
+	restart := func() {
+		if err := sv.watch(ctx, logger, watcher); err != nil {
+			logger.Error("failed to watch code", slog.Any("error", err))
+		}
+
+		builds++
+		binary := filepath.Join(temp, "generator-"+strconv.Itoa(builds))
+		if runtime.GOOS == "windows" {
+			binary += ".exe"
+		}
+
+		if err := sv.build(ctx, logger, binary); err != nil {
+			logger.Error("failed to build generator", slog.Any("error", err))
+			proxy.SetFailure(err)
+			return
+		}
+
+		// the previous generator writes to the same files, so stop it first
+		proxy.SetTarget(nil)
+		current.stop(logger)
+
+		next, err := sv.start(ctx, logger, binary)
+		if err != nil {
+			logger.Error("failed to start generator", slog.Any("error", err))
+			current = nil
+			proxy.SetFailure(err)
+			return
+		}
+
+		current = next
+		proxy.SetFailure(nil)
+		proxy.SetTarget(current.url)
+	}
+
+	debounce := time.NewTimer(0)
+	defer debounce.Stop()
+
+	for {
+		var exited <-chan struct{}
+		if current != nil {
+			exited = current.done
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("context closed: %w", ctx.Err())
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return errors.New("watcher closed")
+			}
+			if !isCode(event.Name) {
+				continue
+			}
+			logger.Info("code changed", slog.String("event", event.String()))
+			debounce.Reset(supervisorDebounce)
+
+		case err := <-watcher.Errors:
+			logger.Error("watcher saw error", slog.Any("error", err))
+
+		case <-debounce.C:
+			restart()
+
+		case <-exited:
+			logger.Error("generator exited", slog.Any("error", current.err))
+			proxy.SetTarget(nil)
+			proxy.SetFailure(fmt.Errorf("generator exited unexpectedly: %w", exitError(current.err)))
+			current = nil
+		}
+	}
+}
+
+// exitError returns the error a process exited with, or an error describing a successful exit if it is nil.
+func exitError(err error) error {
+	if err == nil {
+		return errors.New("exit status 0")
+	}
+	return err
+}
+
+// isCode checks if the file at the given path is part of the code of the generator.
+func isCode(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go") || name == "go.mod" || name == "go.sum"
+}
+
+// watch adds the module directory and the directories of all its packages to watcher.
+// Packages are listed again on every call, so that new packages are picked up.
+func (sv *Supervisor) watch(ctx context.Context, logger *slog.Logger, watcher *rfsnotify.RWatcher) error {
+	cmd := exec.CommandContext(ctx, "go", "list", "-f", "{{.Dir}}", "./...")
+	cmd.Dir = sv.Dir
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to list packages: %w", err)
+	}
+
+	dirs := append([]string{cmp.Or(sv.Dir, ".")}, strings.Fields(string(out))...)
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			return fmt.Errorf("failed to watch %q: %w", dir, err)
+		}
+	}
+	logger.Info("watching code", slog.Int("directories", len(dirs)))
+	return nil
+}
+
+// build builds the generator into the given binary.
+func (sv *Supervisor) build(ctx context.Context, logger *slog.Logger, binary string) error {
+	pkg := cmp.Or(sv.Package, ".")
+
+	logger.Info("building generator", slog.String("package", pkg))
+
+	cmd := exec.CommandContext(ctx, "go", "build", "-o", binary, pkg)
+	cmd.Dir = sv.Dir
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+
+	return fmt.Errorf("failed to build generator: %w", compileErrors(sv.Dir, out, err))
+}
+
+// compileLocation matches the first line of a single error reported by the go compiler.
+var compileLocation = regexp.MustCompile(`^((?:[^\s:]+/)?[^\s:/]+\.go):(\d+)(?::\d+)?: .+$`)
+
+// compileErrors turns the output of "go build" into errors.
+// Each compiler error is annotated with its location, see [file.Error].
+// If the output contains no compiler errors, it is returned as is.
+//
+// Compiler errors follow a "# package" header.
+// Errors of the go command itself, such as missing modules, may also start with a location, but are not compiler errors.
+func compileErrors(dir string, out []byte, err error) error {
+	var (
+		errs     []*file.Error
+		messages []string // message of each error in errs
+		compiler bool     // inside the output of the compiler
+	)
+	for line := range strings.Lines(string(out)) {
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, "# "):
+			compiler = true
+		case !compiler:
+		case strings.HasPrefix(line, "\t") && len(errs) > 0:
+			// details of the previous error, such as "have" and "want" of mismatched types
+			messages[len(messages)-1] += "\n" + line
+		default:
+			match := compileLocation.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+
+			source := filepath.FromSlash(match[1])
+			if !filepath.IsAbs(source) {
+				source = filepath.Join(dir, source)
+			}
+			number, _ := strconv.Atoi(match[2])
+
+			errs = append(errs, &file.Error{Source: filepath.ToSlash(source), Line: number})
+			messages = append(messages, match[0])
+		}
+	}
+
+	if len(errs) == 0 {
+		if message := strings.TrimSpace(string(out)); message != "" {
+			return errors.New(message)
+		}
+		return err
+	}
+
+	joined := make([]error, len(errs))
+	for i, fe := range errs {
+		fe.Err = errors.New(messages[i])
+		joined[i] = fe
+	}
+	return errors.Join(joined...)
+}
+
+// child is a running generator.
+type child struct {
+	cmd *exec.Cmd
+	url *url.URL
+
+	done chan struct{} // closed once the process exited
+	err  error         // error the process exited with, set before done is closed
+}
+
+// startTimeout is the maximal time to wait for a new generator to serve its first generation.
+const startTimeout = time.Minute
+
+// start starts the given binary, and waits for it to serve its first generation.
+func (sv *Supervisor) start(ctx context.Context, logger *slog.Logger, binary string) (*child, error) {
+	addr, err := freeAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(binary, sv.Args...)
+	cmd.Dir = sv.Dir
+	cmd.Env = append(append(os.Environ(), sv.Env...), "ADDR="+addr)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start generator: %w", err)
+	}
+	logger.Info("started generator", slog.Int("pid", cmd.Process.Pid), slog.String("addr", addr))
+
+	c := &child{
+		cmd:  cmd,
+		url:  &url.URL{Scheme: "http", Host: addr},
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(c.done)
+		c.err = cmd.Wait()
+	}()
+
+	if err := c.wait(ctx); err != nil {
+		c.stop(logger)
+		return nil, err
+	}
+	return c, nil
+}
+
+// wait waits until the generator serves its first generation, that is until its index page is found.
+func (c *child) wait(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, startTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.url.String(), nil)
+		if err != nil {
+			return err
+		}
+		if res, err := http.DefaultClient.Do(req); err == nil {
+			res.Body.Close()
+			if res.StatusCode != http.StatusNotFound {
+				return nil
+			}
+		}
+
+		select {
+		case <-c.done:
+			return fmt.Errorf("generator exited before serving: %w", exitError(c.err))
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				// the site may have no index page, so use it anyway
+				return nil
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// stopTimeout is the time a generator has to exit after being interrupted, before it is killed.
+const stopTimeout = 5 * time.Second
+
+// stop stops the generator and waits for it to exit.
+// It is a no-op on a nil child.
+func (c *child) stop(logger *slog.Logger) {
+	if c == nil {
+		return
+	}
+
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	logger.Info("stopping generator", slog.Int("pid", c.cmd.Process.Pid))
+	if runtime.GOOS == "windows" || c.cmd.Process.Signal(os.Interrupt) != nil {
+		_ = c.cmd.Process.Kill()
+	}
+
+	select {
+	case <-c.done:
+	case <-time.After(stopTimeout):
+		logger.Warn("generator did not exit, killing it", slog.Int("pid", c.cmd.Process.Pid))
+		_ = c.cmd.Process.Kill()
+		<-c.done
+	}
+}
+
+// freeAddress returns a currently unused local address to listen on.
+func freeAddress() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to find a free port: %w", err)
+	}
+	addr := listener.Addr().String()
+	if err := listener.Close(); err != nil {
+		return "", fmt.Errorf("failed to find a free port: %w", err)
+	}
+	return addr, nil
+}
@@ -0,0 +1,134 @@
+//spellchecker:words generator
+package supervisor
+
+//spellchecker:words errors slices testing
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"go.tkw01536.de/blog/generator/file"
+)
+
+func TestCompileErrors(t *testing.T) {
+	t.Parallel()
+
+	errBuild := errors.New("exit status 1")
+
+	tests := []struct {
+		name string
+		out  string
+
+		want        []file.Error // Source, Line and message of each compiler error
+		wantMessage string       // message of the returned error if there are no compiler errors
+	}{
+		{
+			name: "unused variable",
+			out:  "# go.tkw01536.de/blog\n./main.go:12:2: declared and not used: x\n",
+			want: []file.Error{
+				{Source: "/src/blog/main.go", Line: 12, Err: errors.New("./main.go:12:2: declared and not used: x")},
+			},
+		},
+		{
+			name: "several errors in a sub package",
+			out: "# go.tkw01536.de/blog/generator\n" +
+				"generator/x.go:3:21: too many return values\n" +
+				"\thave (number)\n" +
+				"\twant ()\n" +
+				"generator/x.go:5:9: undefined: undefinedThing\n",
+			want: []file.Error{
+				{Source: "/src/blog/generator/x.go", Line: 3, Err: errors.New("generator/x.go:3:21: too many return values\n\thave (number)\n\twant ()")},
+				{Source: "/src/blog/generator/x.go", Line: 5, Err: errors.New("generator/x.go:5:9: undefined: undefinedThing")},
+			},
+		},
+		{
+			name: "several packages",
+			out: "# go.tkw01536.de/blog/generator\n" +
+				"generator/main.go:40:1: syntax error: unexpected EOF, expected }\n" +
+				"# go.tkw01536.de/blog/generator/output\n" +
+				"generator/output/s3.go:7:2: \"io\" imported and not used\n",
+			want: []file.Error{
+				{Source: "/src/blog/generator/main.go", Line: 40, Err: errors.New("generator/main.go:40:1: syntax error: unexpected EOF, expected }")},
+				{Source: "/src/blog/generator/output/s3.go", Line: 7, Err: errors.New("generator/output/s3.go:7:2: \"io\" imported and not used")},
+			},
+		},
+		{
+			name: "without column",
+			out:  "# go.tkw01536.de/blog/generator\ngenerator/x.go:3: invalid //go:build line\n",
+			want: []file.Error{
+				{Source: "/src/blog/generator/x.go", Line: 3, Err: errors.New("generator/x.go:3: invalid //go:build line")},
+			},
+		},
+		{
+			name: "outside the directory",
+			out:  "# go.tkw01536.de/blog\n../main.go:4:1: syntax error: unexpected EOF, expected }\n",
+			want: []file.Error{
+				{Source: "/src/main.go", Line: 4, Err: errors.New("../main.go:4:1: syntax error: unexpected EOF, expected }")},
+			},
+		},
+		{
+			name: "absolute path",
+			out:  "# example.com/dependency\n/root/go/pkg/mod/example.com/dependency@v1.0.0/dep.go:8:3: undefined: y\n",
+			want: []file.Error{
+				{Source: "/root/go/pkg/mod/example.com/dependency@v1.0.0/dep.go", Line: 8, Err: errors.New("/root/go/pkg/mod/example.com/dependency@v1.0.0/dep.go:8:3: undefined: y")},
+			},
+		},
+		{
+			name:        "missing module",
+			out:         "go: finding module for package example.com/missing/pkg\nmain.go:3:8: cannot find module providing package example.com/missing/pkg: module lookup disabled by GOPROXY=off\n",
+			wantMessage: "go: finding module for package example.com/missing/pkg\nmain.go:3:8: cannot find module providing package example.com/missing/pkg: module lookup disabled by GOPROXY=off",
+		},
+		{
+			name:        "missing go.mod",
+			out:         "go: go.mod file not found in current directory or any parent directory; see 'go help modules'\n",
+			wantMessage: "go: go.mod file not found in current directory or any parent directory; see 'go help modules'",
+		},
+		{
+			name:        "no output",
+			out:         "",
+			wantMessage: "exit status 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := compileErrors("/src/blog", []byte(tt.out), errBuild)
+			if err == nil {
+				t.Fatal("compileErrors() returned nil")
+			}
+
+			if tt.want == nil {
+				var fe *file.Error
+				if errors.As(err, &fe) {
+					t.Errorf("compileErrors() = %v, want no compiler errors", fe)
+				}
+				if err.Error() != tt.wantMessage {
+					t.Errorf("compileErrors() = %q, want %q", err, tt.wantMessage)
+				}
+				return
+			}
+
+			var errs []error
+			if joined, ok := err.(interface{ Unwrap() []error }); ok {
+				errs = joined.Unwrap()
+			}
+
+			got := make([]file.Error, len(errs))
+			for i, err := range errs {
+				var fe *file.Error
+				if !errors.As(err, &fe) {
+					t.Fatalf("compileErrors() error %d = %v, want a *file.Error", i, err)
+				}
+				got[i] = *fe
+			}
+
+			if !slices.EqualFunc(got, tt.want, func(got, want file.Error) bool {
+				return got.Source == want.Source && got.Line == want.Line && got.Err.Error() == want.Err.Error()
+			}) {
+				t.Errorf("compileErrors() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
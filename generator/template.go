@@ -0,0 +1,165 @@
+//spellchecker:words generator
+package generator
+
+//spellchecker:words bytes sha256 errors html template path filepath slices strings sync
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.tkw01536.de/blog/generator/file"
+)
+
+// TemplateSource provides the template used by a [ContentTemplate] or an [IndexTemplate].
+// It is loaded once at the start of every run.
+type TemplateSource interface {
+	// Load returns the current template, along with a version identifying it.
+	// As long as the underlying source does not change, it should return the same template.
+	//
+	// Versions are used as part of cache keys, and must change whenever the template does.
+	// Unlike the address of the template, they may not be reused by a different template.
+	Load() (tpl *template.Template, version string, err error)
+
+	// Paths returns a list of file system paths this template depends on.
+	// These are watched for changes in Watch mode.
+	Paths() []string
+}
+
+// StaticTemplate returns a [TemplateSource] that always provides the given template.
+// The template must not be modified afterwards.
+func StaticTemplate(tpl *template.Template) TemplateSource {
+	return staticTemplate{
+		tpl:     tpl,
+		version: sync.OnceValue(func() string { return treeVersion(tpl) }),
+	}
+}
+
+type staticTemplate struct {
+	tpl     *template.Template
+	version func() string
+}
+
+func (st staticTemplate) Load() (*template.Template, string, error) {
+	return st.tpl, st.version(), nil
+}
+
+// treeVersion returns a version identifying the parsed contents of tpl and all templates associated with it.
+func treeVersion(tpl *template.Template) string {
+	templates := tpl.Templates()
+	slices.SortFunc(templates, func(left, right *template.Template) int {
+		return strings.Compare(left.Name(), right.Name())
+	})
+
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%q\n", tpl.Name())
+	for _, t := range templates {
+		if t.Tree == nil || t.Tree.Root == nil {
+			continue
+		}
+		_, _ = fmt.Fprintf(h, "%q %q\n", t.Name(), t.Tree.Root.String())
+	}
+	return "tree-" + hex.EncodeToString(h.Sum(nil))
+}
+
+func (st staticTemplate) Paths() []string {
+	return nil
+}
+
+// TemplateFS returns a [TemplateSource] that parses the file with the given name inside fsys.
+// The template is named after the base name of the file, and may use the given functions.
+//
+// The file is read every time the template is loaded, and parsed again when its contents changed.
+func TemplateFS(fsys fs.FS, name string, funcs template.FuncMap) TemplateSource {
+	return &fsTemplate{fsys: fsys, name: name, funcs: funcs}
+}
+
+// TemplateFile is like [TemplateFS], but reads the template from the given path on disk.
+// The path is watched in Watch mode, allowing the template to be edited without restarting.
+func TemplateFile(path string, funcs template.FuncMap) TemplateSource {
+	return &fsTemplate{
+		fsys:  os.DirFS(filepath.Dir(path)),
+		name:  filepath.Base(path),
+		funcs: funcs,
+
+		path: path,
+	}
+}
+
+type fsTemplate struct {
+	fsys  fs.FS
+	name  string
+	funcs template.FuncMap
+
+	path string // path on disk, if any
+
+	m       sync.Mutex
+	source  []byte // source the current template was parsed from
+	version string // hash of name and source
+	tpl     *template.Template
+}
+
+func (ft *fsTemplate) Load() (*template.Template, string, error) {
+	source, err := fs.ReadFile(ft.fsys, ft.name)
+	if err != nil {
+		return nil, "", ft.error(fmt.Errorf("failed to read template %q: %w", ft.name, err))
+	}
+
+	ft.m.Lock()
+	defer ft.m.Unlock()
+
+	if ft.tpl != nil && bytes.Equal(source, ft.source) {
+		return ft.tpl, ft.version, nil
+	}
+
+	name := path.Base(ft.name)
+	tpl, err := template.New(name).Funcs(ft.funcs).Parse(string(source))
+	if err != nil {
+		return nil, "", ft.error(fmt.Errorf("failed to parse template %q: %w", ft.name, err))
+	}
+
+	// functions are part of the code, and cannot change while the generator is running.
+	hash := sha256.Sum256(fmt.Appendf(nil, "%q\n%s", name, source))
+	ft.source, ft.version, ft.tpl = source, "source-"+hex.EncodeToString(hash[:]), tpl
+	return tpl, ft.version, nil
+}
+
+// error annotates err with the source of this template.
+func (ft *fsTemplate) error(err error) error {
+	return file.NewError(file.File{Origin: file.Origin{Scanner: "template", Source: filepath.ToSlash(cmp.Or(ft.path, ft.name))}}, err)
+}
+
+func (ft *fsTemplate) Paths() []string {
+	if ft.path == "" {
+		return nil
+	}
+	return []string{ft.path}
+}
+
+// loadTemplates loads all templates for the current run.
+func (generator *Generator) loadTemplates() error {
+	errs := []error{generator.ContentTemplate.load()}
+	for i := range generator.Indexes {
+		errs = append(errs, generator.Indexes[i].load())
+	}
+	return errors.Join(errs...)
+}
+
+// templatePaths returns the file system paths all templates depend on.
+func (generator *Generator) templatePaths() []string {
+	paths := generator.ContentTemplate.Template.Paths()
+	for _, tpl := range generator.Indexes {
+		paths = append(paths, tpl.Template.Paths()...)
+	}
+	return paths
+}
@@ -1,11 +1,13 @@
 //spellchecker:words generator
 package generator
 
-//spellchecker:words context slog time github farmergreg rfsnotify pkglib errorsx
+//spellchecker:words context errors slog filepath time github farmergreg rfsnotify pkglib errorsx
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"path/filepath"
 	"time"
 
 	"github.com/farmergreg/rfsnotify"
@@ -13,8 +15,13 @@ import (
 	"gopkg.in/fsnotify.v1"
 )
 
-// Watch is like calling [Run] every time a signal is received on the given channel.
+// Watch is like calling [Run] every time a change to the inputs is detected.
 // No two runs of generator occur simultaneously.
+//
+// When a change is detected while a run is in progress, the run is cancelled and a new one is started.
+//
+// Runs are incremental: only sources inside changed paths are scanned again, see [scanner.PartialScanner].
+// Other build steps are skipped when their inputs did not change, as in [Run].
 func (generator *Generator) Watch(ctx context.Context, logger *slog.Logger) error {
 	if ctx == nil {
 		ctx = context.Background()
@@ -29,36 +36,110 @@ func (generator *Generator) Watch(ctx context.Context, logger *slog.Logger) erro
 	}
 	defer closer()
 
-	doBuild := func() {
+	doBuild := func(ctx context.Context) {
 		logger.Info("triggering rebuild")
+		// the error is passed to the output, which may surface it, see [output.Server]
 		err := generator.Run(ctx, logger)
+		if err != nil && ctx.Err() != nil && errors.Is(err, context.Canceled) {
+			logger.Info("rebuild cancelled")
+			return
+		}
 		if err != nil {
 			logger.Error("rebuild failed", slog.Any("err", err))
+			return
 		}
 		logger.Info("rebuild succeeded")
 	}
 
-	debouncedSignal := debounce(watch, time.Second, false)
+	// the build currently in progress, if any
+	var (
+		cancelBuild = func() {}
+		buildDone   = make(chan struct{})
+	)
+	close(buildDone)
+
+	// startBuild cancels the build in progress, waits for it to return and then starts a new one.
+	startBuild := func() {
+		cancelBuild()
+		<-buildDone
+
+		buildCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		cancelBuild, buildDone = cancel, done
+
+		go func() {
+			defer close(done)
+			defer cancel()
+
+			doBuild(buildCtx)
+		}()
+	}
+	defer func() {
+		cancelBuild()
+		<-buildDone
+	}()
 
-	doBuild()
+	// record changed paths as soon as they occur, so that none of them are lost to debouncing
+	generator.scans.enable()
+	changes := make(chan fsnotify.Event)
+	go func() {
+		defer close(changes)
+		for event := range watch {
+			generator.scans.invalidate(event.Name)
+			select {
+			case changes <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	debouncedSignal := debounce(changes, watchDebounce, false)
+
+	startBuild()
 	for {
 		select {
 		case <-ctx.Done():
 			return fmt.Errorf("context closed: %w", ctx.Err())
 		case <-debouncedSignal:
-			doBuild()
+			startBuild()
 		}
 
 	}
 }
 
-// watchScanners watches the inputs of the given scanners.
+// watchDebounce is the time to wait for further changes before rebuilding.
+// It is kept short, as a rebuild is cancelled once further changes arrive.
+const watchDebounce = 50 * time.Millisecond
+
+// newNotifier watches the inputs of the scanners and all templates using the configured [WatchMethod].
 //
 // It returns a triple.
 // The first contains a channel that is sent a signal whenever any directory changes.
 // The seconds is a function to stop watching and close the channel.
 // The third returns an error if watching failed.
 func (generator *Generator) newNotifier(ctx context.Context, logger *slog.Logger) (<-chan fsnotify.Event, func() error, error) {
+	switch generator.WatchMethod {
+	case WatchNative:
+		return generator.nativeNotifier(ctx, logger)
+	case WatchPoll:
+		return generator.pollNotifier(ctx, logger, nil)
+	default:
+		return generator.autoNotifier(ctx, logger)
+	}
+}
+
+// watchPaths returns all file system paths to watch for changes.
+func (generator *Generator) watchPaths() []string {
+	var paths []string
+	for _, scanner := range generator.Inputs {
+		paths = append(paths, scanner.Paths()...)
+	}
+	return append(paths, generator.templatePaths()...)
+}
+
+// nativeNotifier is like [Generator.newNotifier], but uses native file system notifications.
+func (generator *Generator) nativeNotifier(ctx context.Context, logger *slog.Logger) (<-chan fsnotify.Event, func() error, error) {
 	watcher, err := rfsnotify.NewWatcher()
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to watch directories: %w", err)
@@ -77,7 +158,11 @@ func (generator *Generator) newNotifier(ctx context.Context, logger *slog.Logger
 					return
 				}
 				logger.Info("watcher triggered", slog.String("event", e.String()))
-				c <- e
+				select {
+				case c <- e:
+				case <-ctx.Done():
+					return
+				}
 			case e, ok := <-watcher.Errors:
 				logger.Error("watcher saw error", slog.Any("error", e))
 				if !ok {
@@ -98,5 +183,15 @@ func (generator *Generator) newNotifier(ctx context.Context, logger *slog.Logger
 
 	}
 
+	// watch the directories containing templates, as editors commonly replace files when saving
+	for _, path := range generator.templatePaths() {
+		dir := filepath.Dir(path)
+		if err := watcher.Add(dir); err != nil {
+			err := fmt.Errorf("failed to watch %q: %w", dir, err)
+			return nil, nil, errorsx.Combine(err, watcher.Close())
+		}
+		logger.Info("watching template", slog.String("path", path))
+	}
+
 	return c, watcher.Close, nil
 }
@@ -5,12 +5,14 @@ go 1.25.1
 require (
 	github.com/alecthomas/chroma/v2 v2.20.0
 	github.com/farmergreg/rfsnotify v0.0.0-20240825142021-55bd5f2910f6
+	github.com/klauspost/compress v1.18.0
 	github.com/tdewolff/minify/v2 v2.24.3
 	github.com/yuin/goldmark v1.7.12
 	github.com/yuin/goldmark-highlighting/v2 v2.0.0-20230729083705-37449abec8cc
 	github.com/yuin/goldmark-meta v1.1.0
 	go.tkw01536.de/pkglib v0.0.0-20250918085227-d6b24564cb37
 	golang.org/x/net v0.41.0
+	golang.org/x/sys v0.36.0
 	gopkg.in/fsnotify.v1 v1.4.7
 )
 
@@ -18,6 +20,5 @@ require (
 	github.com/dlclark/regexp2 v1.11.5 // indirect
 	github.com/fsnotify/fsnotify v1.9.0 // indirect
 	github.com/tdewolff/parse/v2 v2.8.3 // indirect
-	golang.org/x/sys v0.36.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
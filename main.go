@@ -1,24 +1,28 @@
 //spellchecker:words main
 package main
 
-//spellchecker:words context generator html template slog http signal strings time embed github alecthomas chroma formatters chromahtml yuin goldmark highlighting extension
+//spellchecker:words context generator html template slog http signal filepath regexp strings time embed github alecthomas chroma formatters chromahtml yuin goldmark highlighting extension
 import (
 	"cmp"
 	"context"
+	"embed"
+	"errors"
 	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
 	"go.tkw01536.de/blog/generator"
+	"go.tkw01536.de/blog/generator/cache"
 	"go.tkw01536.de/blog/generator/output"
 	"go.tkw01536.de/blog/generator/scanner"
-
-	_ "embed"
+	"go.tkw01536.de/blog/generator/supervisor"
 
 	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
 	"github.com/yuin/goldmark"
@@ -31,13 +35,32 @@ var globals = map[string]any{
 	"BlogTitle": "High on Code!",
 }
 
-//go:embed "templates/index.html"
-var indexHTML string
-var indexTemplate = mustTemplate(indexHTML, "index.html")
+// watch is set when running in watch mode, see main.
+var watch = os.Getenv("WATCH") != ""
+
+// serverAddr is the address the server listens on in watch mode.
+var serverAddr = cmp.Or(os.Getenv("ADDR"), "localhost:8080")
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+var indexTemplate = templateSource("index.html")
+var listTemplate = templateSource("list.html")
+
+// buildCache persists expensive build results between invocations.
+var buildCache = cache.NewStore(".cache", 512<<20)
+
+var fingerprint = generator.Fingerprint{
+	Match: func(path string) bool {
+		return strings.HasPrefix(path, "styles/") && strings.HasSuffix(path, ".css")
+	},
+}
 
-//go:embed templates/list.html
-var listHTML string
-var listTemplate = mustTemplate(listHTML, "list.html")
+var integrity = generator.SubresourceIntegrity{
+	// External resources only get an integrity attribute once pinned here, together with a vendored copy so that changes fail the build.
+	// "https://inform.everyone.wtf/legal.min.js" is not pinned yet, as no verified copy of it has been vendored.
+	Pinned: map[string]generator.PinnedResource{},
+}
 
 var g = generator.Generator{
 	Inputs: []scanner.Scanner{
@@ -52,6 +75,7 @@ var g = generator.Generator{
 			goldmark.WithExtensions(
 				extension.GFM,
 				extension.Footnote,
+				scanner.Figures,
 				highlighting.NewHighlighting(
 					highlighting.WithStyle("monokai"),
 					highlighting.WithFormatOptions(
@@ -95,10 +119,25 @@ var g = generator.Generator{
 	},
 
 	PostProcessors: []generator.PostProcessor{
+		generator.StripImageMetadata(generator.MetadataAllowlist{
+			Orientation:  true,
+			ColorProfile: true,
+		}),
 		generator.MinifyPostProcessor,
 	},
 
-	Output: output.Native("public", true),
+	Finalizers: []generator.Finalizer{
+		fingerprint.Finalize,
+		integrity.Finalize,
+		(&generator.Precompress{
+			MinSize: 1024,
+			Zstd:    true,
+		}).Finalize,
+	},
+
+	Output: output.NativeAtomic("public"),
+
+	Cache: buildCache,
 }
 
 func main() {
@@ -116,12 +155,54 @@ func main() {
 	// create a new logger
 	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
 
+	// "cache" shows information about the build cache, "cache clear" removes it
+	if len(os.Args) > 1 && os.Args[1] == "cache" {
+		if err := cacheCommand(os.Args[2:]); err != nil {
+			logger.Error("cache command failed", slog.Any("error", err))
+			exitCode = 1
+		}
+		return
+	}
+
+	// "manifest diff old new" shows which urls changed between two builds
+	if len(os.Args) > 1 && os.Args[1] == "manifest" {
+		if err := manifestCommand(os.Args[2:]); err != nil {
+			logger.Error("manifest command failed", slog.Any("error", err))
+			exitCode = 1
+		}
+		return
+	}
+
+	// "supervise" runs watch mode, and restarts it whenever the code of the generator changes
+	if len(os.Args) > 1 && os.Args[1] == "supervise" {
+		sv := supervisor.Supervisor{
+			Addr: serverAddr,
+			Env:  []string{"WATCH=" + cmp.Or(os.Getenv("WATCH"), "1")},
+		}
+		if err := sv.Run(ctx, logger); err != nil && !errors.Is(err, context.Canceled) {
+			logger.Error("supervisor failed", slog.Any("error", err))
+			exitCode = 1
+		}
+		return
+	}
+
 	// running with DEBUG=1 starts a server
-	if os.Getenv("WATCH") != "" {
+	if watch {
 		var server http.Server
-		server.Addr = "localhost:8080"
+		server.Addr = serverAddr
+
+		// serve every build, and keep a copy on disk for inspection
+		var serverOutput output.Output
+		serverOutput, server.Handler = output.Server(output.ServerOptions{NotFound: "404.html"})
+		g.Output = output.Tee(serverOutput, output.NativeSync("public"))
 
-		g.Output, server.Handler = output.Server()
+		// "WATCH=poll" polls for changes, for file systems without notifications
+		switch os.Getenv("WATCH") {
+		case "poll":
+			g.WatchMethod = generator.WatchPoll
+		case "native":
+			g.WatchMethod = generator.WatchNative
+		}
 
 		done := make(chan error, 1)
 		go func() {
@@ -148,17 +229,119 @@ func main() {
 		return
 	}
 
+	// the manifest is only useful for deployments, so watch mode doesn't write it
+	g.ManifestPath = "manifest.json"
+
+	outputs := []output.Output{g.Output}
+
+	// running with ARCHIVE=site.tar.gz or ARCHIVE=site.zip additionally writes an archive
+	if archive := os.Getenv("ARCHIVE"); archive != "" {
+		switch {
+		case strings.HasSuffix(archive, ".tar.gz") || strings.HasSuffix(archive, ".tgz"):
+			outputs = append(outputs, output.Archive(archive, output.TarGzip))
+		case strings.HasSuffix(archive, ".zip"):
+			outputs = append(outputs, output.Archive(archive, output.Zip))
+		default:
+			logger.Error("unknown archive format", slog.String("path", archive))
+			exitCode = 1
+			return
+		}
+	}
+
+	// running with S3_BUCKET=name additionally uploads to an S3-compatible bucket
+	if bucket := os.Getenv("S3_BUCKET"); bucket != "" {
+		outputs = append(outputs, output.S3(output.S3Config{
+			Endpoint:        cmp.Or(os.Getenv("S3_ENDPOINT"), "https://s3.amazonaws.com"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          bucket,
+			Prefix:          os.Getenv("S3_PREFIX"),
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+			CacheControl:    cacheControl,
+			Delete:          os.Getenv("S3_DELETE") != "",
+		}))
+	}
+
+	if len(outputs) > 1 {
+		g.Output = output.Tee(outputs...)
+	}
+
 	// and run
 	if err := g.Run(ctx, logger); err != nil {
 		exitCode = 1
 	}
 }
 
-func mustTemplate(src, name string) *template.Template {
-	return template.Must(template.New(name).Funcs(templateFuncs).Parse(src))
+var errUnknownCommand = errors.New("unknown command")
+
+// cacheCommand implements the "cache" command.
+func cacheCommand(args []string) error {
+	switch {
+	case len(args) == 0:
+		stats, err := buildCache.Stats()
+		if err != nil {
+			return fmt.Errorf("failed to get statistics: %w", err)
+		}
+		fmt.Printf("directory: %s\n", buildCache.Dir())
+		fmt.Printf("entries:   %d\n", stats.Entries)
+		fmt.Printf("size:      %d bytes\n", stats.Size)
+		if stats.Entries > 0 {
+			fmt.Printf("oldest:    %s\n", stats.Oldest.Format(time.RFC3339))
+			fmt.Printf("newest:    %s\n", stats.Newest.Format(time.RFC3339))
+		}
+		return nil
+	case len(args) == 1 && args[0] == "clear":
+		return buildCache.Clear()
+	default:
+		return fmt.Errorf("%w: cache %s", errUnknownCommand, strings.Join(args, " "))
+	}
+}
+
+// cacheControl returns the Cache-Control header used when uploading the file with the given path.
+// Fingerprinted stylesheets never change, everything else is revalidated.
+func cacheControl(path string) string {
+	if fingerprinted.MatchString(path) {
+		return "public, max-age=31536000, immutable"
+	}
+	return "public, max-age=300, must-revalidate"
+}
+
+// fingerprinted matches paths of files renamed by [generator.Fingerprint].
+var fingerprinted = regexp.MustCompile(`\.[0-9a-f]{16}\.[^./]+(\.gz|\.zst)?$`)
+
+// manifestCommand implements the "manifest" command.
+func manifestCommand(args []string) error {
+	if len(args) != 3 || args[0] != "diff" {
+		return fmt.Errorf("%w: manifest %s", errUnknownCommand, strings.Join(args, " "))
+	}
+
+	before, err := generator.ReadManifest(args[1])
+	if err != nil {
+		return fmt.Errorf("failed to read old manifest: %w", err)
+	}
+	after, err := generator.ReadManifest(args[2])
+	if err != nil {
+		return fmt.Errorf("failed to read new manifest: %w", err)
+	}
+
+	for _, change := range generator.DiffManifests(before, after) {
+		fmt.Printf("%-7s %s\n", change.Kind(), change.URL())
+	}
+	return nil
+}
+
+// templateSource returns the source of the template with the given name.
+// Templates are embedded into the binary, but read from disk in watch mode so that they can be edited without restarting.
+func templateSource(name string) generator.TemplateSource {
+	if watch {
+		return generator.TemplateFile(filepath.Join("templates", name), templateFuncs)
+	}
+	return generator.TemplateFS(embeddedTemplates, "templates/"+name, templateFuncs)
 }
 
 var templateFuncs = template.FuncMap{
+	"asset": fingerprint.Asset,
 	"date": func(arg string) (string, error) {
 		date, err := time.Parse("2006-01-02", arg)
 		if err != nil {